@@ -1,4 +1,5 @@
 import sys
+import json
 import pickle
 from Bio import SeqIO
 from sklearn.feature_extraction.text import HashingVectorizer
@@ -25,22 +26,66 @@ def get_kmers(seq, size=kmer_len):
 def seq_to_vector(fasta_file):
     # list of kmers of size kmer_len per seq
     seq_list = fasta_to_list(fasta_file)
+    return seqs_to_vector(seq_list)
+
+
+def seqs_to_vector(seq_list):
     kmer_lists = [get_kmers(seq) for seq in seq_list]
     return VECTORIZER.transform(kmer_lists).toarray()
 
 
+def predict(model, seq_list):
+    # prob that label of seq is 1 (DNAzyme) according to model
+    if len(seq_list) == 0:
+        return []
+    predictions = model.predict_proba(seqs_to_vector(seq_list))
+    return [float(x[1]) for x in predictions]
+
+
 def main(fasta_file, model_file):
     # load trained model
     model = pickle.load(open(model_file, 'rb'))
     # prep sequence to evaluate
-    X = seq_to_vector(fasta_file)
-    # prob that label of seq is 1 (DNAzyme) according to model
-    predictions = model.predict_proba(X)
-    return [x[1] for x in predictions]
+    return predict(model, fasta_to_list(fasta_file))
+
+
+def reply(message):
+    sys.stdout.write(json.dumps(message) + '\n')
+    sys.stdout.flush()
+
+
+def serve(model_file):
+    """ Long lived worker used by the genetic algorithm, the model is loaded once
+        and requests are read from stdin as newline delimited json
+          {"id": 1, "ping": true}          -> {"id": 1, "pong": true}
+          {"id": 2, "seqs": ["ACGT", ...]} -> {"id": 2, "predictions": [0.1, ...]}
+        a request that fails gets {"id": n, "error": "..."} instead
+        input: pickled model file name
+        output: none, returns when stdin is closed
+    """
+    model = pickle.load(open(model_file, 'rb'))
+    reply({"ready": True})
+    for line in sys.stdin:
+        if not line.strip():
+            continue
+        request_id = None
+        try:
+            request = json.loads(line)
+            request_id = request.get("id")
+            if request.get("ping"):
+                reply({"id": request_id, "pong": True})
+            else:
+                reply({"id": request_id,
+                       "predictions": predict(model, request["seqs"])})
+        except Exception as e:
+            reply({"id": request_id, "error": repr(e)})
 
 
 if __name__ == '__main__':
-    fasta_file = sys.argv[1]
-    model_file = sys.argv[2]
-    output = main(fasta_file, model_file)
-    print(' '.join(["%.9f" % x for x in output]))
+    if sys.argv[1] == '--serve':
+        serve(sys.argv[2])
+    else:
+        fasta_file = sys.argv[1]
+        model_file = sys.argv[2]
+        output = main(fasta_file, model_file)
+        print(' '.join(["%.9f" % x for x in output]))
@@ -0,0 +1,57 @@
+import sys
+import json
+import pickle
+import sklearn
+from dnazyme_classifier import kmer_len, n_features, fasta_to_list, seqs_to_vector, predict
+
+"""
+Exports a pickled sklearn SGDClassifier to a portable json file
+so the genetic algorithm can score sequences natively in Go
+without calling python.
+The json records the k-mer length and HashingVectorizer settings used
+by dnazyme_classifier.py along with the model coefficients.
+"""
+
+
+def export_model(model_file, json_file):
+    """ Write the parameters of a trained model to a json file
+        input: pickle file of the model, output json file name
+        output: no return, writes json_file
+    """
+    model = pickle.load(open(model_file, 'rb'))
+    params = {'kmer_len': kmer_len,
+              'n_features': n_features,
+              'alternate_sign': True,  # HashingVectorizer defaults
+              'norm': 'l2',
+              'loss': model.loss,
+              'classes': [int(c) for c in model.classes_],
+              'coef': [float(c) for c in model.coef_[0]],
+              'intercept': float(model.intercept_[0])}
+    with open(json_file, 'w') as outfile:
+        json.dump(params, outfile)
+
+
+def export_expected(model_file, json_file, fasta_file, expected_file):
+    """ Write the decision function and predict_proba of the model for some sequences,
+        to compare the Go classifier against sklearn
+        input: pickle file of the model, its json export, fasta of sequences, output json file name
+        output: no return, writes expected_file
+    """
+    model = pickle.load(open(model_file, 'rb'))
+    seqs = fasta_to_list(fasta_file)
+    expected = {'model': json_file.split('/')[-1],
+                'generated_by': 'sklearn ' + sklearn.__version__,
+                'sequences': seqs,
+                'decision_function': [float(d) for d in model.decision_function(seqs_to_vector(seqs))],
+                'predict_proba': predict(model, seqs)}
+    with open(expected_file, 'w') as outfile:
+        json.dump(expected, outfile, indent=1)
+
+
+if __name__ == '__main__':
+    # export_model.py model.pickle model.json [seqs.fasta expected.json]
+    model_file = sys.argv[1]
+    json_file = sys.argv[2]
+    export_model(model_file, json_file)
+    if len(sys.argv) == 5:
+        export_expected(model_file, json_file, sys.argv[3], sys.argv[4])
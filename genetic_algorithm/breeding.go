@@ -2,8 +2,10 @@ package main
 
 import(
     "fmt"
+    "math"
+    "sync"
+    "time"
     "math/rand"
-    "github.com/biogo/biogo/seq/linear"
     "github.com/cheggaaa/pb"
 )
 
@@ -14,7 +16,7 @@ import(
 func MakeRandomSeq(length int) string {
     var seq string
     for i := 0; i < length; i++ {
-        seq = seq + PickRandomBase() // pick a random element from the alphabet
+        seq = seq + PickRandomBase(RNG) // pick a random element from the alphabet
     }
     return seq
 }
@@ -27,47 +29,40 @@ func MakeRandomSequence(length int) Member {
     return s
 }
 // InitializeGeneration() create a random pool of sequences to start our gentic algorithm
+// seeded members from -seed-pop come first and random members fill up the population
 // input:  the number of sequences to generate and lower,upper bounds onsequence length
+//         members follow the breeder's template instead if it is not nil
+//         random members follow the composition, see composition.go
 // output: a new random population (slice of Sequences) with size members
-func InitializeGeneration(size,lower,upper int,target *linear.Seq, model_file string) Population {
+func InitializeGeneration(size,lower,upper int, breeder Breeder, seeding Seeding, composition Composition, fitness FitnessFunction) Population {
     population := make(Population,size)
-    for i := 0; i < size; i++ {
-        population[i] = MakeRandomSequence(RandomIntBetween(lower,upper))
+    seeded := copy(population,seeding.Members(size,breeder))
+    for i := seeded; i < size; i++ {
+        population[i] = composition.RandomMember(lower,upper,breeder.template)
     }
-    population.ScoreFitness(target, model_file)
+    population.ScoreFitness(fitness)
     return population
 }
 
 //Breed new generation of Sequences
-// Crossover() creates a new sequence by crossing over with some input sequence at a random locus and rescores the new sequence
-// input: sequences to crossover
+// Crossover() creates a new sequence by crossing over with some input sequence and rescores the new sequence
+// input: random numbers to draw from, sequence to crossover and the crossover operator, see crossover.go
 // output: new sequence that is a hybrid of the inputs
-func (s Member) Crossover(t Member) string {
-    crossOverIndex := RandomIntBetween(0,Min(len(s.seq),len(t.seq))) //where to crossover
-    // combine front half of s.seq and back half of t.seq
-    return s.seq[0:crossOverIndex] + t.seq[crossOverIndex:len(t.seq)]
+func (s Member) Crossover(rng *rand.Rand, t Member, operator Crossover) string {
+    return operator(rng,s.seq,t.seq)
+}
+// CrossoverSeqs() crosses over 2 DNA strings at a random locus
+// output: front of s and back of t
+func CrossoverSeqs(rng *rand.Rand, s,t string) string {
+    crossOverIndex := RandomIntBetween(rng,0,Min(len(s),len(t))) //where to crossover
+    // combine front half of s and back half of t
+    return s[0:crossOverIndex] + t[crossOverIndex:len(t)]
 }
 // Mutate() mutates a DNA sequence at each position with some probability
-// input: probability that each site will be mutated
+// input: random numbers to draw from, mutation model, probability that each site will be mutated and that a mutation is an indel
 // output: sequence with mutations
-func (s Member) Mutate(mutation_rate,indel_rate float64) string {
-    var mutated string
-    for _,base := range s.seq {
-        if rand.Float64() > mutation_rate {//dont mutate
-            mutated = mutated + string(base)
-        } else {//mutate this base to a new base
-            if rand.Float64() > indel_rate {//regular mutation
-                mutated = mutated + PickDifferentRandomBase(base)
-            } else {
-                if rand.Intn(2) == 0 {// insert new base
-                    mutated = mutated + string(base) + PickRandomBase()
-                } else {
-                    //delete base (add nothing)
-                }
-            }
-        }
-    }
-    return mutated
+func (s Member) Mutate(rng *rand.Rand, model *MutationModel, mutation_rate,indel_rate float64) string {
+    return model.Mutate(rng,s.seq,mutation_rate,indel_rate)
 }
 // GetFittestMembers() selects the fittest members from the current population
 // for breeding the next generation
@@ -78,39 +73,87 @@ func GetFittestMembers(generation Population, top_sequence_percent float64) Popu
     index := len(generation) - int(float64(len(generation))*top_sequence_percent)
     return generation.SortByFitness()[index:len(generation)]
 }
-// BreedSequence() breeds a new sequence from a population
-// input: some set of sequences
-// output: a single new sequence bred from 2 random population members
-func BreedSequence(pop Population, label int,target *linear.Seq, mutation_rate,indel_rate float64, model_file string) Member {
-    seq1 := pop[rand.Intn(len(pop))] //pick a random Sequence
-    seq2 := pop[rand.Intn(len(pop))] //pick another random Sequence
-    newSequence := Member{seq:seq1.seq}
-    newSequence.seq = newSequence.Crossover(seq2)
-    newSequence.seq = newSequence.Mutate(mutation_rate,indel_rate)
+// BreedSequence() breeds a new sequence from 2 parents
+// the child is crossed over with probability crossover_rate and then mutated
+// input: random numbers to draw from, the parents, label of the new member and the breeding settings
+// output: a single new sequence bred from the 2 parents
+func BreedSequence(rng *rand.Rand, seq1,seq2 Member, label int, breeder Breeder) Member {
+    template := breeder.template
+    crosses := breeder.Crosses(rng)
+    operator := CROSSOVERS[breeder.crossover]
+    parent_fitness := seq1.fitness
+    if crosses {
+        parent_fitness = math.Max(seq1.fitness,seq2.fitness)
+    }
+    if template != nil {//breed region by region
+        segments := seq1.segments
+        if crosses {
+            segments = template.Crossover(rng,seq1.segments,seq2.segments,operator)
+        }
+        segments = template.Mutate(rng,segments,breeder.mutation_model,breeder.mutation_rate,breeder.indel_rate)
+        return Member{seq:template.Join(segments),segments:segments,label:label,bred:true,parent_fitness:parent_fitness}
+    }
+    newSequence := Member{seq:seq1.seq,bred:true,parent_fitness:parent_fitness}
+    if crosses {
+        newSequence.seq = newSequence.Crossover(rng,seq2,operator)
+    }
+    newSequence.seq = newSequence.Mutate(rng,breeder.mutation_model,breeder.mutation_rate,breeder.indel_rate)
     newSequence.label = label
     return newSequence
 }
 // BreedNewGeneration() create a new population from previous best members and breeding new members from them
-// input: a population of sequences and how many you will pick (proportion is in (0,1)
+// the elites are copied unchanged and the parents of every other member are picked by the selector,
+// the niching mode may change how they are picked, see niching.go
+// input: the island's random numbers, a population of sequences, the fitness function and the breeding settings
 // output: new population of Sequences
-func BreedNewGeneration(generation Population, target *linear.Seq, mutation_rate float64, indel_rate float64, top_sequence_percent float64, model_file string) Population {
+func BreedNewGeneration(rng *rand.Rand, generation Population, fitness FitnessFunction, breeder Breeder) Population {
+    if breeder.niching == "crowding" {//replaces selection
+        return CrowdingGeneration(rng,generation,fitness,breeder)
+    }
+    candidates := generation //members the selector picks from
+    raw := generation.FitnessBySeq() //fitness of the picked members, not shared
+    if breeder.niching == "sharing" {
+        candidates = generation.SharedFitness(breeder)
+    }
     nextGeneration := make(Population,len(generation))
-    fittestMembers := GetFittestMembers(generation,top_sequence_percent)
+    fittestMembers := breeder.selector.Elites(candidates,breeder.top_sequence_percent)
+    if breeder.niching == "no-duplicates" {
+        fittestMembers = fittestMembers.Collapse()
+    }
+    fittestMembers = fittestMembers.RawFitness(raw)
+    seen := make(map[string]bool,len(generation)) //sequences already in the next generation
     for i,member := range fittestMembers {
-        member.label = i
-        nextGeneration[i] = Member{label:i,
-                                     fitness:member.fitness,
-                                     seq:member.seq,
-                                    }
+        nextGeneration[i] = member.Survivor(i)
+        seen[member.seq] = true
     }
+    //breed new sequences untill our new generation is same size as previous
+    parents := breeder.selector.Parents(rng,candidates,fittestMembers,2*(len(nextGeneration)-len(fittestMembers)))
+    parents = parents.RawFitness(raw)
     for i:=len(fittestMembers);i<len(nextGeneration);i++ {
-        //breed new sequences untill our new generation is same size as previous
-        nextGeneration[i] = BreedSequence(fittestMembers,i,target,mutation_rate,indel_rate,model_file)
+        p := 2*(i-len(fittestMembers))
+        nextGeneration[i] = BreedSequence(rng,parents[p],parents[p+1],i,breeder)
+        for retry := 0; breeder.niching == "no-duplicates" && seen[nextGeneration[i].seq] && retry < MAX_CLONE_RETRIES; retry++ {
+            pair := breeder.selector.Parents(rng,candidates,fittestMembers,2).RawFitness(raw)
+            nextGeneration[i] = BreedSequence(rng,pair[0],pair[1],i,breeder)
+        }
+        seen[nextGeneration[i].seq] = true
     }
-    nextGeneration.ScoreFitness(target, model_file)
+    nextGeneration.ScoreFitness(fitness)
     return nextGeneration
 }
 
+// LogGeneration() prints one line of the per generation log
+// input: label of the population, generation number and the mutation rate used to breed the next generation
+func (pop Population) LogGeneration(prefix string, gen int, mutation_rate float64) {
+    fitnesses := pop.FitnessList()
+    line := prefix + fmt.Sprintf("Generation %d\tmean fitness %f\tmax fitness %f\tentropy %f\tmutation rate %g",
+                        gen,Mean(fitnesses),MaxFloat(fitnesses),pop.Entropy(),mutation_rate)
+    if success, bred := pop.SuccessRate(); bred {
+        line += fmt.Sprintf("\tsuccess %f",success)
+    }
+    fmt.Println(line)
+}
+
 //Decide when to stop breeding new generations
 // FitnessPlateau() checks if a population has plateaued in fitness
 // actually checks if covarinace of mean fitness of the last n generations is < tolerance
@@ -128,44 +171,119 @@ func FitnessPlateau(mode string, fitnesses [][]float64, fitness_plateau_toleranc
         case "cov": //CoV of fitness for last generation
             return (CoV(fitnesses[len(fitnesses)-1]) < fitness_plateau_tolerance)
         default:
-            panic("Invalid plateau mode, must be {cov_mean|std_dev}")
+            panic("Invalid plateau mode, must be {cov_mean|cov}")
         }
 }
-// RunSimulation() runs a genetic algorithm and returns the final generation
-// input: simulation parameters as commented
-// output: last (most fit) generation (list of members)
+// RunSimulation() runs a genetic algorithm on every island and returns the final generation
+// input: simulation parameters as commented, one Breeder per island, checkpoint settings
+// and the generation history file ("" for none)
+// output: last (most fit) generation of every island merged (list of members), the islands
+// and why the run stopped
 func RunSimulation(lower int,
                    upper int,
                    size int,
                    maxIterations int,
                    targetFile string,
-                   mutation_rate float64,
-                   indel_rate float64,
-                   top_sequence_percent float64,
-                   model_file string,
-                   fitness_mode string,
-                   fitness_plateau_tolerance float64,
-                   plateau_gens int) Population {
-    target := ReadTargetFromFasta(targetFile)
-    target.RevComp()
-    target.Reverse() //no complement method so do reverse(reverse complement
-    currentGen := InitializeGeneration(size,lower,upper,target,model_file)
-    bar := pb.StartNew(maxIterations).Prefix("Generations:")
-    var generationFitnesses [][]float64 //list of fitness values for all solutions for each generation
-    for gen := 0; gen < maxIterations; gen++ {//terminate regardless after maxIterations
-        //keep only last plateau_gens generational fitnesses stored
-        generationFitnesses = generationFitnesses[Max(0,len(generationFitnesses)-plateau_gens):len(generationFitnesses)]
-        generationFitnesses = append(generationFitnesses,currentGen.FitnessList())
-        //check if average fitness has plateaued
-        if FitnessPlateau(fitness_mode,generationFitnesses,fitness_plateau_tolerance,) {
-            bar.Finish()
-            fmt.Println("Reached Fitness Plateau at generation ",gen)
-            return currentGen //if plateau, no improvements from continnuing simulation, finish
+                   ctx FitnessContext,
+                   fitness_spec string,
+                   breeders []Breeder,
+                   migration Migration,
+                   seeding Seeding,
+                   composition Composition,
+                   stopper Stopper,
+                   checkpointing Checkpointing,
+                   history_file string,
+                   verbose bool) (Population, []*Island, string) {
+    stopper.start = time.Now()
+    HandleInterrupts()
+    ctx = PrepareContext(ctx,targetFile)
+    fitness := ParseFitnessFunction(fitness_spec,ctx)
+    FITNESS_CACHE.Open(FitnessSignature(fitness_spec,ctx))
+    islands := make([]*Island,len(breeders))
+    seed, _ := RANDOM_SOURCE.State()
+    for i,breeder := range breeders {
+        breeder.selector = NewSelector(breeder,fitness)
+        islands[i] = NewIsland(i,breeder,IslandSeed(seed,i))
+        if checkpointing.resume == nil {
+            islands[i].population = InitializeGeneration(size,lower,upper,breeder,seeding,composition,fitness)
+        }
+        if len(breeders) > 1 {
+            islands[i].prefix = fmt.Sprintf("Island %d\t",i)
         }
-        currentGen = BreedNewGeneration(currentGen,target,mutation_rate,indel_rate,top_sequence_percent,model_file)
-        bar.Increment()
-    }//never reached fitness plateau
-    bar.Finish()
-    fmt.Println("Reached Max Iterations ",maxIterations)
-    return currentGen
+    }
+    from := 0 //generation the islands start from
+    if checkpointing.resume != nil {
+        from = checkpointing.resume.Restore(islands,&stopper)
+        fmt.Println("Resuming from generation ",from)
+    }
+    history := OpenHistory(history_file,stopper.start,checkpointing.resume)
+    var bar *pb.ProgressBar
+    if !verbose {//the per generation log replaces the progress bar
+        bar = pb.StartNew(maxIterations*len(islands)).Prefix("Generations:")
+        done := 0
+        for _,island := range islands {
+            done += island.gen
+        }
+        bar.Set(done)
+    }
+    interval := maxIterations //no migration with one island
+    if len(islands) > 1 {
+        interval = migration.interval
+    }
+    //epochs end at every migration and checkpoint
+    for until := checkpointing.Next(from,interval,maxIterations); ; until = checkpointing.Next(until,interval,maxIterations) {
+        var wg sync.WaitGroup
+        for _,island := range islands {//islands evolve in parallel until the next migration
+            wg.Add(1)
+            go func(island *Island) {
+                defer wg.Done()
+                island.Evolve(until,fitness,stopper,history,verbose,bar)
+            }(island)
+        }
+        wg.Wait()
+        if until == maxIterations || AllStopped(islands) {
+            break
+        }
+        if until%interval == 0 {
+            Migrate(islands,migration)
+        }
+        if checkpointing.Due(until) {
+            checkpointing.Save(until,islands,stopper)
+            if verbose {
+                fmt.Println("Checkpoint written to ",checkpointing.filename)
+            }
+        }
+    }
+    if !verbose {
+        bar.Finish()
+    }
+    for _,island := range islands {//the final generation of every island
+        history.Record(island)
+    }
+    history.Close()
+    reason := fmt.Sprintf("max_iterations (%d)",maxIterations) //never met the stop rule
+    switch {
+        case Interrupted():
+            last := 0
+            for _,island := range islands {
+                last = Max(last,island.gen)
+            }
+            reason = fmt.Sprintf("interrupted at generation %d",last)
+            fmt.Println("Interrupted at generation ",last)
+        case len(islands) == 1 && len(islands[0].stop) != 0:
+            reason = islands[0].stop
+            fmt.Println("Stopped at generation ",islands[0].gen,": ",reason)
+        case len(islands) == 1:
+            fmt.Println("Reached Max Iterations ",maxIterations)
+        case AllStopped(islands):
+            reason = "every island met the stop rule"
+    }
+    lastGen := MergeIslands(islands)
+    for _,island := range islands {
+        if island.breeder.selection == "nsga2" {//report the front of every member in the output
+            lastGen.AssignParetoRanks(fitness.Objectives())
+            break
+        }
+    }
+    return lastGen, islands, reason
 }
@@ -0,0 +1,207 @@
+package main
+
+import(
+    "os"
+    "fmt"
+    "math"
+    "sync"
+    "bufio"
+    "crypto/sha1"
+    "container/list"
+    "encoding/json"
+)
+
+//Fitness cache
+//fitness only depends on the sequence once the fitness function and target are fixed,
+//so scores are memoised by sequence in an LRU cache, elites and repeated offspring are
+//then not re-scored every generation
+//the cache can be saved to a file and reused by later runs with the same settings,
+//the file starts with a signature of the settings and is ignored if it does not match
+
+// CachedFitness is everything ScoreFitness() assigns to a member
+type CachedFitness struct {
+    Seq string `json:"seq"`
+    Fitness float64 `json:"fitness"`
+    Scores map[string]float64 `json:"scores"`
+    Annotations map[string]float64 `json:"annotations,omitempty"`
+}
+// FitnessCache is a sequence keyed LRU cache of fitness scores
+type FitnessCache struct {
+    capacity int
+    filename string //"" to keep the cache in memory only
+    signature string //settings the cached scores are valid for
+    entries map[string]*list.Element
+    recent *list.List //most recently used at the front
+    hits int
+    misses int
+    lock sync.Mutex
+}
+
+var FITNESS_CACHE *FitnessCache //nil when caching is off, set with -cache
+
+// NewFitnessCache() an empty fitness cache
+// input: most sequences to keep and the file to persist to, "" for none
+// output: pointer to the cache
+func NewFitnessCache(capacity int, filename string) *FitnessCache {
+    return &FitnessCache{capacity:capacity,
+                         filename:filename,
+                         entries:make(map[string]*list.Element),
+                         recent:list.New(),
+                        }
+}
+// FitnessSignature() identifies the settings fitness depends on
+// input: fitness spec and the prepared fitness context
+// output: hex digest, equal for runs whose scores can be shared
+func FitnessSignature(fitness_spec string, ctx FitnessContext) string {
+    if content, err := os.ReadFile(fitness_spec); err == nil {//spec is a config file
+        fitness_spec = string(content)
+    }
+    settings := fmt.Sprintf("%s|%s|%s|%s|%d|%+v|%d",fitness_spec,ctx.target_seq,ctx.model_file,ctx.model_url,ctx.min_hairpin,ctx.conditions,ctx.offtarget_mismatches)
+    if info, err := os.Stat(ctx.model_file); ctx.model_url == "" && err == nil {//a retrained model under the same name
+        settings += fmt.Sprintf("|%d|%d",info.Size(),info.ModTime().UnixNano())
+    }
+    if ctx.template != nil {//templated members are folded and screened by their parts
+        settings += "|" + ctx.template.name
+    }
+    if ctx.offtarget != nil {
+        settings += fmt.Sprintf("|%s|%d|%d|%d",ctx.offtarget.path,ctx.offtarget.k,ctx.offtarget.background_size,ctx.offtarget.background_time)
+    }
+    return fmt.Sprintf("%x",sha1.Sum([]byte(settings)))
+}
+// Open() sets the settings signature and loads the cache file if it matches
+// input: signature from FitnessSignature()
+// output: no return, entries from the file are added to the cache
+func (c *FitnessCache) Open(signature string) {
+    if c == nil {
+        return
+    }
+    c.signature = signature
+    if c.filename == "" {
+        return
+    }
+    cacheFile, err := os.Open(c.filename)
+    if os.IsNotExist(err) {
+        return
+    }
+    if err != nil { panic(err) }
+    defer cacheFile.Close()
+    scanner := bufio.NewScanner(cacheFile)
+    scanner.Buffer(make([]byte,1<<16),1<<26)
+    if !scanner.Scan() || scanner.Text() != "#"+signature {
+        fmt.Println("Fitness cache ",c.filename," was made with other settings, ignoring it")
+        return
+    }
+    for scanner.Scan() {//oldest first, so the most recent end up at the front
+        var entry CachedFitness
+        if err := json.Unmarshal(scanner.Bytes(),&entry); err != nil { panic(err) }
+        c.Put(entry)
+    }
+    if err := scanner.Err(); err != nil { panic(err) }
+}
+// Get() cached fitness of a sequence, counting the hit or miss
+func (c *FitnessCache) Get(seq string) (CachedFitness, bool) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    element, ok := c.entries[seq]
+    if !ok {
+        c.misses++
+        return CachedFitness{}, false
+    }
+    c.hits++
+    c.recent.MoveToFront(element)
+    return element.Value.(CachedFitness), true
+}
+// Put() adds a sequence's fitness, evicting the least recently used if full
+func (c *FitnessCache) Put(entry CachedFitness) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    if element, ok := c.entries[entry.Seq]; ok {
+        element.Value = entry
+        c.recent.MoveToFront(element)
+        return
+    }
+    c.entries[entry.Seq] = c.recent.PushFront(entry)
+    for c.recent.Len() > c.capacity {
+        oldest := c.recent.Back()
+        c.recent.Remove(oldest)
+        delete(c.entries,oldest.Value.(CachedFitness).Seq)
+    }
+}
+// Save() writes the cache to its file, least recently used first
+func (c *FitnessCache) Save() {
+    if c == nil || c.filename == "" {
+        return
+    }
+    outfile, err := os.Create(c.filename)
+    if err != nil { panic(err) }
+    defer outfile.Close()
+    writer := bufio.NewWriter(outfile)
+    writer.WriteString("#" + c.signature + "\n")
+    for element := c.recent.Back(); element != nil; element = element.Prev() {
+        entry := element.Value.(CachedFitness)
+        entry.Annotations = FiniteValues(entry.Annotations) //json has no NaN or Inf, these are written as NA anyway
+        line, err := json.Marshal(entry)
+        if err != nil { panic(err) }
+        writer.Write(append(line,'\n'))
+    }
+    if err := writer.Flush(); err != nil { panic(err) }
+}
+// Entries() every cached fitness, least recently used first, and the hit and miss counts
+func (c *FitnessCache) Entries() ([]CachedFitness, int, int) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    entries := make([]CachedFitness,0,c.recent.Len())
+    for element := c.recent.Back(); element != nil; element = element.Prev() {
+        entries = append(entries,element.Value.(CachedFitness))
+    }
+    return entries, c.hits, c.misses
+}
+// Restore() replaces the cache with the entries and counts Entries() returned
+func (c *FitnessCache) Restore(entries []CachedFitness, hits int, misses int) {
+    c.lock.Lock()
+    c.entries = make(map[string]*list.Element)
+    c.recent = list.New()
+    c.hits, c.misses = hits, misses
+    c.lock.Unlock()
+    for _,entry := range entries {
+        c.Put(entry)
+    }
+}
+// Summarize() prints the cache hit and miss counts
+func (c *FitnessCache) Summarize() {
+    if c == nil {
+        return
+    }
+    fmt.Println("Fitness Cache")
+    fmt.Println("-------------------------------------")
+    fmt.Println("Hits.............",c.hits)
+    fmt.Println("Misses           ",c.misses)
+    fmt.Println("Hit Rate.........",float64(c.hits)/float64(Max(1,c.hits+c.misses)))
+    fmt.Println("Cached           ",c.recent.Len())
+    fmt.Println("-------------------------------------")
+}
+
+// CopyScores() copy of a score map, so members never share one
+func CopyScores(scores map[string]float64) map[string]float64 {
+    if scores == nil {
+        return nil
+    }
+    copied := make(map[string]float64,len(scores))
+    for name,value := range scores {
+        copied[name] = value
+    }
+    return copied
+}
+// FiniteValues() copy of a score map without NaN and Inf values
+func FiniteValues(scores map[string]float64) map[string]float64 {
+    if scores == nil {
+        return nil
+    }
+    finite := make(map[string]float64,len(scores))
+    for name,value := range scores {
+        if !math.IsNaN(value) && !math.IsInf(value,0) {
+            finite[name] = value
+        }
+    }
+    return finite
+}
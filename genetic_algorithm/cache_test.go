@@ -0,0 +1,28 @@
+package main
+
+import(
+    "os"
+    "time"
+    "testing"
+    "path/filepath"
+)
+
+// TestFitnessSignature checks a changed model file or a template changes the signature
+func TestFitnessSignature(t *testing.T) {
+    model_file := filepath.Join(t.TempDir(),"model.json")
+    if err := os.WriteFile(model_file,[]byte("{}"),0644); err != nil { t.Fatal(err) }
+    ctx := FitnessContext{model_file:model_file,target_seq:"ACGT"}
+    signature := FitnessSignature("classifier",ctx)
+    if FitnessSignature("classifier",ctx) != signature {
+        t.Error("signature changed with the same settings")
+    }
+    if err := os.Chtimes(model_file,time.Now(),time.Now().Add(time.Hour)); err != nil { t.Fatal(err) }
+    retrained := FitnessSignature("classifier",ctx)
+    if retrained == signature {
+        t.Error("signature unchanged after the model file was rewritten")
+    }
+    ctx.template = ParseTemplate("10-23")
+    if FitnessSignature("classifier",ctx) == retrained {
+        t.Error("signature unchanged with a template")
+    }
+}
@@ -0,0 +1,223 @@
+package main
+
+import(
+    "os"
+    "fmt"
+    "flag"
+    "time"
+    "sync/atomic"
+    "encoding/gob"
+)
+
+//Checkpoints
+//with -checkpoint-every N the state of the run is saved every N generations to -checkpoint-file,
+//replacing the previous checkpoint, and -resume continues a run from its checkpoint exactly as
+//if it had not stopped, a checkpoint holds
+//  every flag value, so the resumed run has the same settings
+//  the population, generation and stop condition state (e.g. the plateau window) of every island
+//  the mutation rate of every island's schedule
+//  the state of RNG and of every island's random number generator, see random.go
+//  the fitness evaluations so far and the fitness cache
+//  the time taken so far, for time stop conditions
+//checkpoints are gob encoded so every float, including NaN and Inf scores, is restored exactly
+
+var RESUME_FLAGS = []string{"resume","checkpoint-every","checkpoint-file","workers","verbose"} //flags a resumed run takes from the command line
+
+// Checkpointing holds the checkpoint settings of a run
+type Checkpointing struct {
+    every int //generations between checkpoints, 0 for none
+    filename string
+    params map[string]string //every flag value
+    resume *Checkpoint //checkpoint to continue from, nil for a new run
+}
+// Checkpoint is the saved state of a run
+type Checkpoint struct {
+    Generation int //generation every island had reached or stopped before
+    Params map[string]string
+    Seed int64 //state of RNG
+    Draws uint64
+    Evaluations int64
+    Elapsed time.Duration
+    Islands []SavedIsland
+    Cache []CachedFitness //fitness cache, least recently used first, nil without a cache
+    CacheHits int
+    CacheMisses int
+}
+// SavedIsland is the saved state of an island
+type SavedIsland struct {
+    Gen int
+    Population []SavedMember
+    History [][]float64
+    Best []float64
+    Mean []float64
+    Stop string
+    MutationRate float64
+    ScheduleRate float64
+    Seed int64 //state of the island's random number generator
+    Draws uint64
+}
+// SavedMember is a Member with exported fields for encoding
+type SavedMember struct {
+    Seq string
+    Fitness float64
+    Label int
+    Header string
+    Scores map[string]float64
+    Annotations map[string]float64
+    Segments []string
+    Rank int
+    Crowding float64
+    Bred bool
+    ParentFitness float64
+    Copies int
+}
+
+// FlagValues() value of every flag, to save in checkpoints
+func FlagValues() map[string]string {
+    params := make(map[string]string)
+    flag.VisitAll(func(f *flag.Flag) {
+        params[f.Name] = f.Value.String()
+    })
+    return params
+}
+// ReadCheckpoint() reads a checkpoint written by Save()
+func ReadCheckpoint(filename string) *Checkpoint {
+    infile, err := os.Open(filename)
+    if err != nil { panic(err) }
+    defer infile.Close()
+    var checkpoint Checkpoint
+    if err := gob.NewDecoder(infile).Decode(&checkpoint); err != nil {
+        panic(fmt.Sprintf("Invalid checkpoint %s: %v",filename,err))
+    }
+    return &checkpoint
+}
+// RestoreParams() sets every flag to its value in the checkpoint except RESUME_FLAGS,
+// flags given on the command line with other values are ignored with a warning
+func (checkpoint *Checkpoint) RestoreParams() {
+    given := make(map[string]bool)
+    flag.Visit(func(f *flag.Flag) {
+        given[f.Name] = true
+    })
+    for name,value := range checkpoint.Params {
+        f := flag.Lookup(name)
+        switch {
+            case f == nil:
+                panic("Checkpoint has unknown parameter " + name)
+            case InList(name,RESUME_FLAGS):
+                continue
+            case given[name] && f.Value.String() != value:
+                fmt.Printf("Ignoring -%s %s, resuming with the checkpoint's %s\n",name,f.Value.String(),value)
+        }
+        if err := flag.Set(name,value); err != nil { panic(err) }
+    }
+}
+
+// Next() last generation of the next epoch, the islands evolve until it then migrate or checkpoint
+// input: current generation, generations between migrations and the last generation
+func (checkpointing Checkpointing) Next(gen,interval,maxIterations int) int {
+    next := Min(maxIterations,(gen/interval+1)*interval)
+    if checkpointing.every > 0 {
+        next = Min(next,(gen/checkpointing.every+1)*checkpointing.every)
+    }
+    return next
+}
+// Due() whether a checkpoint is written at generation gen
+func (checkpointing Checkpointing) Due(gen int) bool {
+    return checkpointing.every > 0 && gen%checkpointing.every == 0
+}
+// Save() writes the state of the run at generation gen, replacing the previous checkpoint
+// the checkpoint is written to a temporary file first so a crash while writing keeps the previous one
+func (checkpointing Checkpointing) Save(gen int, islands []*Island, stopper Stopper) {
+    checkpoint := Checkpoint{Generation:gen,
+                             Params:checkpointing.params,
+                             Evaluations:atomic.LoadInt64(&FITNESS_EVALUATIONS),
+                             Elapsed:time.Since(stopper.start),
+                            }
+    checkpoint.Seed, checkpoint.Draws = RANDOM_SOURCE.State()
+    for _,island := range islands {
+        saved := SavedIsland{Gen:island.gen,
+                             History:island.state.history,
+                             Best:island.state.best,
+                             Mean:island.state.mean,
+                             Stop:island.stop,
+                             MutationRate:island.breeder.mutation_rate,
+                             ScheduleRate:island.breeder.schedule.rate,
+                            }
+        saved.Seed, saved.Draws = island.source.State()
+        for _,member := range island.population {
+            saved.Population = append(saved.Population,member.Save())
+        }
+        checkpoint.Islands = append(checkpoint.Islands,saved)
+    }
+    if FITNESS_CACHE != nil {
+        checkpoint.Cache, checkpoint.CacheHits, checkpoint.CacheMisses = FITNESS_CACHE.Entries()
+    }
+    temporary := checkpointing.filename + ".tmp"
+    outfile, err := os.Create(temporary)
+    if err != nil { panic(err) }
+    if err := gob.NewEncoder(outfile).Encode(checkpoint); err != nil { panic(err) }
+    if err := outfile.Close(); err != nil { panic(err) }
+    if err := os.Rename(temporary,checkpointing.filename); err != nil { panic(err) }
+}
+// Restore() puts the islands, stop rule clock, fitness cache and random number generators
+// in their state at the checkpoint
+// input: islands with their breeders, the stop rule
+// output: generation to continue from
+func (checkpoint *Checkpoint) Restore(islands []*Island, stopper *Stopper) int {
+    if len(islands) != len(checkpoint.Islands) {
+        panic(fmt.Sprintf("Checkpoint has %d islands, the run has %d",len(checkpoint.Islands),len(islands)))
+    }
+    for i,saved := range checkpoint.Islands {
+        island := islands[i]
+        island.gen = saved.Gen
+        island.state = StopState{history:saved.History,best:saved.Best,mean:saved.Mean}
+        island.stop = saved.Stop
+        island.breeder.mutation_rate = saved.MutationRate
+        island.breeder.schedule.rate = saved.ScheduleRate
+        island.source.Restore(saved.Seed,saved.Draws)
+        island.population = make(Population,len(saved.Population))
+        for j,member := range saved.Population {
+            island.population[j] = member.Restore()
+        }
+    }
+    if FITNESS_CACHE != nil {
+        FITNESS_CACHE.Restore(checkpoint.Cache,checkpoint.CacheHits,checkpoint.CacheMisses)
+    }
+    atomic.StoreInt64(&FITNESS_EVALUATIONS,checkpoint.Evaluations)
+    stopper.start = time.Now().Add(-checkpoint.Elapsed)
+    RANDOM_SOURCE.Restore(checkpoint.Seed,checkpoint.Draws)
+    return checkpoint.Generation
+}
+
+// Save() the member with exported fields
+func (m Member) Save() SavedMember {
+    return SavedMember{Seq:m.seq,
+                       Fitness:m.fitness,
+                       Label:m.label,
+                       Header:m.header,
+                       Scores:m.scores,
+                       Annotations:m.annotations,
+                       Segments:m.segments,
+                       Rank:m.rank,
+                       Crowding:m.crowding,
+                       Bred:m.bred,
+                       ParentFitness:m.parent_fitness,
+                       Copies:m.copies,
+                      }
+}
+// Restore() the member that was saved
+func (m SavedMember) Restore() Member {
+    return Member{seq:m.Seq,
+                  fitness:m.Fitness,
+                  label:m.Label,
+                  header:m.Header,
+                  scores:m.Scores,
+                  annotations:m.Annotations,
+                  segments:m.Segments,
+                  rank:m.Rank,
+                  crowding:m.Crowding,
+                  bred:m.Bred,
+                  parent_fitness:m.ParentFitness,
+                  copies:m.Copies,
+                 }
+}
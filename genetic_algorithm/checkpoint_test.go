@@ -0,0 +1,57 @@
+package main
+
+import(
+    "os"
+    "testing"
+    "path/filepath"
+)
+
+// RunIslands() a short 2 island run with the master seed, tournament selection and random migration
+func RunIslands(t *testing.T, seed int64, checkpointing Checkpointing) Population {
+    target := filepath.Join(t.TempDir(),"target.fna")
+    if err := os.WriteFile(target,[]byte(">target\nGGATCCATGCAAGTTCGATCGGCTAGCTTAGC\n"),0644); err != nil { t.Fatal(err) }
+    RANDOM_SOURCE.Seed(seed)
+    base := Breeder{selection:"tournament",
+                    tournament_size:3,
+                    top_sequence_percent:0.2,
+                    crossover:"one-point",
+                    crossover_rate:0.5,
+                    mutation_model:ParseMutationModel("uniform"),
+                    mutation_rate:0.05,
+                    schedule:NewMutationSchedule("constant",0.05,0.01,0.5,12),
+                    indel_rate:0.1,
+                    niching:"none",
+                    niche_distance:"hamming",
+                    niche_radius:1,
+                   }
+    pop, _, _ := RunSimulation(10,20,20,12,target,FitnessContext{},"gc:1",
+                               IslandBreeders(base,"",2),
+                               Migration{interval:3,migrants:2,topology:"random"},
+                               Seeding{},
+                               NewComposition("","","uniform",0,0),
+                               ParseStopRule("","cov_mean",0,5),
+                               checkpointing,"",false)
+    return pop
+}
+
+// TestResumeIslands checks a 2 island run resumed from a checkpoint ends as the uninterrupted run does
+func TestResumeIslands(t *testing.T) {
+    uninterrupted := RunIslands(t,9,Checkpointing{})
+    checkpoint_file := filepath.Join(t.TempDir(),"run.gob")
+    checkpointed := RunIslands(t,9,Checkpointing{every:5,filename:checkpoint_file,params:FlagValues()})
+    checkpoint := ReadCheckpoint(checkpoint_file)
+    if checkpoint.Generation != 10 {
+        t.Fatalf("checkpoint at generation %d, want 10",checkpoint.Generation)
+    }
+    resumed := RunIslands(t,1,Checkpointing{resume:checkpoint}) //the seed is restored from the checkpoint
+    for name,pop := range map[string]Population{"checkpointed":checkpointed,"resumed":resumed} {
+        if len(pop) != len(uninterrupted) {
+            t.Fatalf("%s run has %d members, the uninterrupted run %d",name,len(pop),len(uninterrupted))
+        }
+        for i := range pop {
+            if pop[i].seq != uninterrupted[i].seq || pop[i].fitness != uninterrupted[i].fitness {
+                t.Errorf("%s member %d is %s (%v), uninterrupted %s (%v)",name,i,pop[i].seq,pop[i].fitness,uninterrupted[i].seq,uninterrupted[i].fitness)
+            }
+        }
+    }
+}
@@ -0,0 +1,182 @@
+package main
+
+import(
+    "os"
+    "math"
+    "regexp"
+    "sync"
+    "strings"
+    "encoding/json"
+    "encoding/binary"
+)
+
+// KmerClassifier is a native Go port of dnazyme_classifier.py
+// it reproduces the sklearn HashingVectorizer over 6-mers and the
+// SGDClassifier predict_proba so no python is needed for scoring
+// parameters are exported from the pickle with export_model.py
+type KmerClassifier struct {
+    KmerLen int `json:"kmer_len"`
+    NFeatures int `json:"n_features"`
+    AlternateSign bool `json:"alternate_sign"`
+    Norm string `json:"norm"`
+    Loss string `json:"loss"`
+    Classes []int `json:"classes"`
+    Coef []float64 `json:"coef"`
+    Intercept float64 `json:"intercept"`
+}
+
+//default sklearn token_pattern, every kmer is a single token
+var TOKEN_PATTERN = regexp.MustCompile(`\b\w\w+\b`)
+//models already read from disk, so the json is parsed once per run
+var loadedClassifiers = map[string]*KmerClassifier{}
+var loadedClassifiersLock sync.Mutex //terms are scored from many goroutines
+
+// LoadClassifier() reads model parameters from a json file exported by export_model.py
+// input: json file name
+// output: pointer to a KmerClassifier, panics if the file is invalid
+func LoadClassifier(model_file string) *KmerClassifier {
+    loadedClassifiersLock.Lock()
+    defer loadedClassifiersLock.Unlock()
+    if model, ok := loadedClassifiers[model_file]; ok {
+        return model
+    }
+    jsonFile, err := os.Open(model_file)
+    if err != nil { panic(err) }
+    defer jsonFile.Close()
+    var model KmerClassifier
+    err = json.NewDecoder(jsonFile).Decode(&model)
+    if err != nil { panic(err) }
+    switch false {
+        case model.KmerLen > 0:
+            panic("model kmer_len must be > 0")
+        case model.NFeatures > 0:
+            panic("model n_features must be > 0")
+        case len(model.Classes) == 2:
+            panic("model must be a binary classifier")
+        case model.Classes[0] == 0 && model.Classes[1] == 1:
+            panic("model classes must be [0,1], the probability of class 1 is taken as DNAzyme")
+        case len(model.Coef) == model.NFeatures:
+            panic("model coef must have n_features entries")
+        case model.Loss == "modified_huber" || model.Loss == "log" || model.Loss == "log_loss":
+            panic("model loss must be one of {modified_huber|log|log_loss}, no predict_proba otherwise")
+    }
+    loadedClassifiers[model_file] = &model
+    return &model
+}
+
+// GetKmers() splits a sequence into overlapping kmers joined by spaces
+// same as get_kmers() in dnazyme_classifier.py
+// input: DNA string and kmer size
+// output: space delimited string of kmers
+func GetKmers(seq string, size int) string {
+    var kmers []string
+    for x := 0; x < len(seq)-size+1; x++ {
+        kmers = append(kmers,strings.ToUpper(seq[x:x+size]))
+    }
+    return strings.Join(kmers," ")
+}
+
+// Murmur3() 32 bit murmurhash3 (x86 variant), as used by sklearn
+// input: bytes to hash and the seed
+// output: signed 32 bit hash, sklearn uses the signed value
+func Murmur3(data []byte, seed uint32) int32 {
+    const c1, c2 = 0xcc9e2d51, 0x1b873593
+    h := seed
+    nblocks := len(data)/4
+    for i := 0; i < nblocks; i++ {
+        k := binary.LittleEndian.Uint32(data[i*4:])
+        k *= c1
+        k = (k << 15) | (k >> 17)
+        k *= c2
+        h ^= k
+        h = (h << 13) | (h >> 19)
+        h = h*5 + 0xe6546b64
+    }
+    tail := data[nblocks*4:]
+    var k uint32
+    switch len(tail) {//fall through the remaining bytes
+        case 3:
+            k ^= uint32(tail[2]) << 16
+            fallthrough
+        case 2:
+            k ^= uint32(tail[1]) << 8
+            fallthrough
+        case 1:
+            k ^= uint32(tail[0])
+            k *= c1
+            k = (k << 15) | (k >> 17)
+            k *= c2
+            h ^= k
+    }
+    h ^= uint32(len(data))
+    h ^= h >> 16
+    h *= 0x85ebca6b
+    h ^= h >> 13
+    h *= 0xc2b2ae35
+    h ^= h >> 16
+    return int32(h)
+}
+
+// Vectorize() hashes the kmers of a sequence into a feature vector
+// the same way HashingVectorizer(n_features) does with default settings
+// input: DNA string
+// output: hashed kmer vector of length n_features, l2 normalised
+func (model *KmerClassifier) Vectorize(seq string) []float64 {
+    vector := make([]float64,model.NFeatures)
+    //HashingVectorizer lowercases before tokenizing
+    for _,token := range TOKEN_PATTERN.FindAllString(strings.ToLower(GetKmers(seq,model.KmerLen)),-1) {
+        h := Murmur3([]byte(token),0)
+        index := int(math.Abs(float64(h))) % model.NFeatures
+        value := 1.0
+        if model.AlternateSign && h < 0 {
+            value = -1.0
+        }
+        vector[index] += value
+    }
+    if model.Norm == "l2" {
+        norm := 0.0
+        for _,v := range vector {
+            norm += v*v
+        }
+        if norm > 0 {
+            norm = math.Sqrt(norm)
+            for i := range vector {
+                vector[i] /= norm
+            }
+        }
+    }
+    return vector
+}
+
+// DecisionFunction() signed distance of a sequence to the separating hyperplane
+func (model *KmerClassifier) DecisionFunction(seq string) float64 {
+    score := model.Intercept
+    for i,v := range model.Vectorize(seq) {
+        score += v*model.Coef[i]
+    }
+    return score
+}
+
+// PredictProba() probability that a sequence is a DNAzyme (class 1)
+// matches SGDClassifier.predict_proba()[:,1] for the model loss
+// input: DNA string
+// output: probability in [0,1]
+func (model *KmerClassifier) PredictProba(seq string) float64 {
+    score := model.DecisionFunction(seq)
+    switch model.Loss {
+        case "modified_huber":
+            return (math.Max(-1,math.Min(1,score))+1)/2
+        default: //log loss, logistic regression
+            return 1/(1+math.Exp(-score))
+    }
+}
+
+// PredictPopulation() probability that each member of a population is a DNAzyme
+// output: slice of probabilities in the same order as pop
+func (model *KmerClassifier) PredictPopulation(pop Population) []float64 {
+    predictions := make([]float64,len(pop))
+    for i,member := range pop {
+        predictions[i] = model.PredictProba(member.seq)
+    }
+    return predictions
+}
@@ -0,0 +1,61 @@
+package main
+
+import(
+    "os"
+    "math"
+    "testing"
+    "path/filepath"
+    "encoding/json"
+)
+
+//recorded decision function and predict_proba of the default model for a few sequences, so changes
+//to the Go classifier that change its scores are caught
+//the values come from a pure python reimplementation of the vectorizer and classifier reading the
+//pickled coefficients, not from sklearn, so this is a regression test and not a check against sklearn
+const REGRESSION_FILE = "testdata/classifier_regression.json"
+const REGRESSION_TOLERANCE = 1e-9
+
+// TestClassifierRegression checks the Go classifier still gives the recorded decision function and predict_proba
+func TestClassifierRegression(t *testing.T) {
+    content, err := os.ReadFile(REGRESSION_FILE)
+    if err != nil { t.Fatal(err) }
+    var expected struct {
+        Model string `json:"model"`
+        Sequences []string `json:"sequences"`
+        DecisionFunction []float64 `json:"decision_function"`
+        PredictProba []float64 `json:"predict_proba"`
+    }
+    if err := json.Unmarshal(content,&expected); err != nil { t.Fatal(err) }
+    model := LoadClassifier(filepath.Join("..","dnazyme_ML_model",expected.Model))
+    for i,seq := range expected.Sequences {
+        if d := model.DecisionFunction(seq); math.Abs(d-expected.DecisionFunction[i]) > REGRESSION_TOLERANCE {
+            t.Errorf("decision function of %s is %v, recorded %v",seq,d,expected.DecisionFunction[i])
+        }
+        if p := model.PredictProba(seq); math.Abs(p-expected.PredictProba[i]) > REGRESSION_TOLERANCE {
+            t.Errorf("predict_proba of %s is %v, recorded %v",seq,p,expected.PredictProba[i])
+        }
+    }
+}
+
+// TestMurmur3 checks the hash against known MurmurHash3 x86_32 values
+func TestMurmur3(t *testing.T) {
+    if h := Murmur3([]byte("hello"),0); h != 613153351 {
+        t.Errorf("murmur3 of hello is %d, want 613153351",h)
+    }
+    if h := Murmur3([]byte("Hello, world!"),1234); h != -84488781 {
+        t.Errorf("murmur3 of Hello, world! is %d, want -84488781",h)
+    }
+}
+
+// TestLoadClassifierClasses checks models whose class 1 is not the second column are rejected
+func TestLoadClassifierClasses(t *testing.T) {
+    model_file := filepath.Join(t.TempDir(),"model.json")
+    content := `{"kmer_len":6,"n_features":1,"classes":[1,0],"coef":[0.5],"intercept":0,"loss":"modified_huber"}`
+    if err := os.WriteFile(model_file,[]byte(content),0644); err != nil { t.Fatal(err) }
+    defer func() {
+        if recover() == nil {
+            t.Error("model with classes [1,0] was loaded")
+        }
+    }()
+    LoadClassifier(model_file)
+}
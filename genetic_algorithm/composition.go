@@ -0,0 +1,212 @@
+package main
+
+import(
+    "fmt"
+    "math"
+    "strings"
+    "strconv"
+)
+
+//Composition of the initial population
+//random members are drawn so the starting pool resembles a synthesizable oligo library
+//  base frequencies  relative frequency of A,C,G,T, uniform by default
+//  GC range          the GC content of every random sequence is in [min,max], the number of G+C
+//                    is drawn uniformly from the counts in range and the rest follow the frequencies
+//  lengths           uniform in [lower,upper) as before, normal around the middle of [lower,upper]
+//                    truncated to it, or empirical, drawn from the lengths of a fasta file
+//  homopolymers      sequences with a run of the same base longer than max_homopolymer are redrawn
+//with a template the frequencies, GC range and homopolymer filter apply to each mutable region
+
+const MAX_COMPOSITION_TRIES = 1000 //draws of a sequence before the constraints are deemed impossible
+
+// Composition describes how random sequences are drawn
+type Composition struct {
+    frequencies [4]float64 //relative frequency of A,C,G,T, sums to 1
+    gc_min float64
+    gc_max float64
+    lengths string //uniform, normal or empirical
+    length_sd float64 //standard deviation of normal lengths
+    empirical []int //lengths to draw from for empirical lengths
+    max_homopolymer int //longest run of one base allowed, 0 for any
+}
+
+// NewComposition() composition from the flag values
+// input: base frequencies as "A,C,G,T" ("" for uniform), GC range as "MIN-MAX" ("" for any),
+//        length distribution {uniform|normal} or a fasta file, sd of normal lengths, longest homopolymer
+// output: Composition, panics on invalid values
+func NewComposition(frequencies,gc_range,lengths string, length_sd float64, max_homopolymer int) Composition {
+    c := Composition{frequencies:[4]float64{0.25,0.25,0.25,0.25},
+                     gc_min:0,
+                     gc_max:1,
+                     lengths:lengths,
+                     length_sd:length_sd,
+                     max_homopolymer:max_homopolymer}
+    if len(frequencies) != 0 {
+        values := strings.Split(frequencies,",")
+        if len(values) != 4 {
+            panic("Invalid base frequencies " + frequencies + ", must be 4 numbers A,C,G,T")
+        }
+        total := 0.0
+        for i,value := range values {
+            f, err := strconv.ParseFloat(strings.TrimSpace(value),64)
+            if err != nil || f < 0 {
+                panic("Invalid base frequencies " + frequencies + ", must be 4 numbers >= 0")
+            }
+            c.frequencies[i] = f
+            total += f
+        }
+        if total == 0 {
+            panic("Invalid base frequencies " + frequencies + ", at least one must be > 0")
+        }
+        for i := range c.frequencies {
+            c.frequencies[i] /= total
+        }
+    }
+    if len(gc_range) != 0 {
+        bounds := strings.Split(gc_range,"-")
+        min, err := strconv.ParseFloat(bounds[0],64)
+        if err != nil { panic(err) }
+        max := min
+        if len(bounds) == 2 {
+            max, err = strconv.ParseFloat(bounds[1],64)
+            if err != nil { panic(err) }
+        }
+        if len(bounds) > 2 || !Between(min,0,1) || !Between(max,min,1) {
+            panic(fmt.Sprintf("Invalid GC range %s, must be F or MIN-MAX with 0 <= MIN <= MAX <= 1",gc_range))
+        }
+        c.gc_min, c.gc_max = min, max
+    }
+    switch lengths {
+        case "uniform","normal":
+        default://lengths of the sequences in a fasta file
+            for _,member := range FastaToPopulation(lengths) {
+                if len(member.seq) > 0 {
+                    c.empirical = append(c.empirical,len(member.seq))
+                }
+            }
+            if len(c.empirical) == 0 {
+                panic("No sequences to take lengths from in " + lengths)
+            }
+            c.lengths = "empirical"
+    }
+    if max_homopolymer < 0 {
+        panic("max homopolymer must be >= 0")
+    }
+    return c
+}
+
+// Uniform() whether sequences are drawn as originally, every base equally likely and no filters
+func (c Composition) Uniform() bool {
+    return c.frequencies == [4]float64{0.25,0.25,0.25,0.25} && c.gc_min == 0 && c.gc_max == 1 && c.max_homopolymer == 0
+}
+// Length() random sequence length from the length distribution
+// input: lower and upper bounds of uniform lengths, normal lengths are in [lower,upper]
+func (c Composition) Length(lower,upper int) int {
+    switch c.lengths {
+        case "normal":
+            sd := c.length_sd
+            if sd <= 0 {
+                sd = float64(upper-lower)/4
+            }
+            mean := float64(lower+upper)/2
+            length := int(math.Round(mean + RNG.NormFloat64()*sd))
+            return Min(upper,Max(lower,length))
+        case "empirical":
+            return c.empirical[RNG.Intn(len(c.empirical))]
+        default:
+            return RandomIntBetween(RNG,lower,upper)
+    }
+}
+// RandomSeq() random DNA string following the composition
+// the length is redrawn if no GC count of it is in range, sequences with long homopolymers
+// are redrawn with the same length so the length distribution is kept
+// input: function drawing the length
+// output: DNA string, panics if none meeting the constraints is drawn in MAX_COMPOSITION_TRIES
+func (c Composition) RandomSeq(length func() int) string {
+    if c.Uniform() {
+        return MakeRandomSeq(length())
+    }
+    n := length()
+    for tries := 0; tries < MAX_COMPOSITION_TRIES; tries++ {
+        seq, ok := c.Draw(n)
+        if !ok {
+            n = length()
+            continue
+        }
+        if c.max_homopolymer == 0 || LongestRun(seq) <= c.max_homopolymer {
+            return seq
+        }
+    }
+    panic(fmt.Sprintf("Could not draw a sequence with GC in [%g,%g] and no run longer than %d, loosen the composition",
+                      c.gc_min,c.gc_max,c.max_homopolymer))
+}
+// Draw() one random DNA string of the given length with its GC content in range
+// output: the sequence and false if no GC count of this length is in range
+func (c Composition) Draw(length int) (string, bool) {
+    gc := c.frequencies[1]+c.frequencies[2]
+    if c.gc_min == 0 && c.gc_max == 1 {//no GC constraint, draw every base from the frequencies
+        seq := make([]byte,length)
+        for i := range seq {
+            seq[i] = PickBase(c.frequencies[:])
+        }
+        return string(seq), true
+    }
+    low, high := int(math.Ceil(c.gc_min*float64(length)-1e-9)), int(math.Floor(c.gc_max*float64(length)+1e-9))
+    if low > high {
+        return "", false
+    }
+    count := low + RNG.Intn(high-low+1) //number of G+C
+    if (gc == 0 && count > 0) || (gc == 1 && count < length) {
+        return "", false //frequencies forbid this GC content
+    }
+    seq := make([]byte,length)
+    for n,i := range RNG.Perm(length) {
+        if n < count {//G or C with their relative frequencies
+            seq[i] = "CG"[PickIndex([]float64{c.frequencies[1],c.frequencies[2]})]
+        } else {//A or T
+            seq[i] = "AT"[PickIndex([]float64{c.frequencies[0],c.frequencies[3]})]
+        }
+    }
+    return string(seq), true
+}
+// PickBase() random base with the relative frequencies of A,C,G,T
+func PickBase(frequencies []float64) byte {
+    return byte(DNA_ALPHABET[PickIndex(frequencies)])
+}
+// PickIndex() random index with probability proportional to its weight, uniform if every weight is 0
+func PickIndex(weights []float64) int {
+    total := Sum(weights)
+    if total == 0 {
+        return RNG.Intn(len(weights))
+    }
+    r := RNG.Float64()*total
+    for i,weight := range weights {
+        if r < weight {
+            return i
+        }
+        r -= weight
+    }
+    return len(weights)-1 //only reached through rounding
+}
+// LongestRun() length of the longest run of one base
+func LongestRun(seq string) int {
+    longest, run := 0, 0
+    for i := range seq {
+        if i > 0 && seq[i] == seq[i-1] {
+            run++
+        } else {
+            run = 1
+        }
+        longest = Max(longest,run)
+    }
+    return longest
+}
+
+// RandomMember() random member following the composition, and the template if it is not nil
+// input: lower and upper bounds of the length without a template
+func (c Composition) RandomMember(lower,upper int, template *Template) Member {
+    if template != nil {
+        return template.MakeTemplateMember(c)
+    }
+    return Member{seq:c.RandomSeq(func() int { return c.Length(lower,upper) })}
+}
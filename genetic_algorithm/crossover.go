@@ -0,0 +1,103 @@
+package main
+
+import(
+    "sort"
+    "math/rand"
+    "github.com/biogo/biogo/alphabet"
+    "github.com/biogo/biogo/seq/linear"
+)
+
+//Crossover operators
+//each combines 2 parent DNA strings into a child, set with -crossover
+//  one-point   both parents cut at the same index (the original crossover)
+//  two-point   the middle of the first parent between 2 indices is replaced by the second's
+//  uniform     each position is taken from either parent with equal probability
+//  homologous  the parents are aligned with SW and cut at aligned positions, so
+//              motifs are not shifted when indels made the parents differ in length
+//  none        no crossover, the child is a copy of the first parent
+
+// Crossover is a crossover operator, it draws its cut points from rng
+type Crossover func(rng *rand.Rand, s,t string) string
+
+// CROSSOVERS maps the names usable in -crossover to the operators
+var CROSSOVERS = map[string]Crossover{
+    "one-point": CrossoverSeqs,
+    "two-point": TwoPointCrossover,
+    "uniform": UniformCrossover,
+    "homologous": HomologousCrossover,
+    "none": func(rng *rand.Rand, s,t string) string { return s },
+}
+
+// CrossoverNames() sorted names of every crossover operator
+func CrossoverNames() []string {
+    var names []string
+    for name := range CROSSOVERS {
+        names = append(names,name)
+    }
+    sort.Strings(names)
+    return names
+}
+// Crosses() decides if a child is crossed over, with the breeder's crossover probability
+func (breeder Breeder) Crosses(rng *rand.Rand) bool {
+    return breeder.crossover != "none" && rng.Float64() < breeder.crossover_rate
+}
+
+// TwoPointCrossover() replaces s[i:j] with t[i:j] for random i <= j
+func TwoPointCrossover(rng *rand.Rand, s,t string) string {
+    n := Min(len(s),len(t))
+    i, j := rng.Intn(n+1), rng.Intn(n+1)
+    if i > j {
+        i, j = j, i
+    }
+    return s[:i] + t[i:j] + s[j:]
+}
+// UniformCrossover() takes each position from s or t with equal probability
+// positions past the end of the shorter parent come from s
+func UniformCrossover(rng *rand.Rand, s,t string) string {
+    child := []byte(s)
+    for i := 0; i < Min(len(s),len(t)); i++ {
+        if rng.Intn(2) == 0 {
+            child[i] = t[i]
+        }
+    }
+    return string(child)
+}
+// HomologousCrossover() cuts s and t at a random pair of aligned positions
+// the parents are aligned with SW_MATRIX and the cut is made inside an ungapped
+// aligned block, falls back to one-point crossover if the parents do not align
+// output: front of s up to the cut and back of t from the aligned position
+func HomologousCrossover(rng *rand.Rand, s,t string) string {
+    aligned := AlignedPositions(s,t)
+    if len(aligned) == 0 {
+        return CrossoverSeqs(rng,s,t)
+    }
+    cut := aligned[rng.Intn(len(aligned))]
+    return s[:cut[0]] + t[cut[1]:]
+}
+// AlignedPositions() pairs of positions of s and t aligned to each other by SW
+// output: list of [position in s, position in t], empty if the alignment fails
+func AlignedPositions(s,t string) [][2]int {
+    var aligned [][2]int
+    if len(s) == 0 || len(t) == 0 {
+        return aligned
+    }
+    seqS := &linear.Seq{Seq:alphabet.BytesToLetters([]byte(s))}
+    seqS.Alpha = ALPHABET //set alphabet, required by biogo
+    seqT := &linear.Seq{Seq:alphabet.BytesToLetters([]byte(t))}
+    seqT.Alpha = ALPHABET
+    aln, err := SW_MATRIX.Align(seqS,seqT)
+    if err != nil {
+        return aligned
+    }
+    for _,pair := range aln {
+        features := pair.Features()
+        a, b := features[0], features[1]
+        if a.End()-a.Start() != b.End()-b.Start() {//a gap in one of the parents
+            continue
+        }
+        for i := 0; i < a.End()-a.Start(); i++ {
+            aligned = append(aligned,[2]int{a.Start()+i,b.Start()+i})
+        }
+    }
+    return aligned
+}
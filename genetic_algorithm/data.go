@@ -2,8 +2,10 @@ package main
 
 import(
     "math"
+    "time"
     "github.com/biogo/biogo/alphabet"
     "github.com/biogo/biogo/align"
+    "github.com/biogo/biogo/seq/linear"
 )
 
 //Constants
@@ -29,8 +31,62 @@ type Member struct {
     fitness float64
     label int
     header string
+    scores map[string]float64 //score of each fitness term
+    annotations map[string]float64 //extra values from the fitness terms for output, e.g. Tm
+    segments []string //sequence of each template region, nil without a template
+    rank int //Pareto front, 1 is the best front and 0 if not ranked
+    crowding float64 //NSGA-II crowding distance within the front
+    bred bool //bred this generation, false for elites and initial members
+    parent_fitness float64 //fitness of the fittest parent of a bred member
+    copies int //identical members collapsed into this one for output, 0 if not collapsed
 }
 type Population []Member
+// FitnessTerm is one component of the fitness function
+// Score returns a score for every member of the population, in the same order
+type FitnessTerm interface {
+    Name() string
+    Score(pop Population) []float64
+}
+type WeightedTerm struct {
+    term FitnessTerm
+    weight float64
+}
+// FitnessFunction is a weighted combination of fitness terms
+type FitnessFunction []WeightedTerm
+// FitnessContext holds everything fitness terms may need to score members
+type FitnessContext struct {
+    target *linear.Seq
+    target_seq string //target as given, 5'->3'
+    model_file string
+    model_timeout time.Duration
+    model_url string //classifier served over HTTP, used instead of model_file
+    model_batch int
+    model_retries int
+    model_concurrency int
+    min_hairpin int
+    conditions Conditions
+    offtarget *OffTargetIndex //nil without a background
+    offtarget_mismatches int
+    template *Template //template of the members, nil for none
+}
+// Breeder is everything needed to breed a new generation
+type Breeder struct {
+    selection string //one of SELECTIONS
+    selector Selector //made from the selection settings when the simulation starts
+    tournament_size int
+    selection_pressure float64 //for rank selection, in [1,2]
+    top_sequence_percent float64 //fraction of members kept as elites
+    crossover string //name in CROSSOVERS
+    crossover_rate float64 //probability a child is crossed over
+    mutation_model *MutationModel
+    mutation_rate float64 //set every generation by the schedule
+    schedule *MutationSchedule
+    indel_rate float64
+    template *Template //nil for no template
+    niching string //one of NICHINGS
+    niche_distance string //one of NICHE_DISTANCES
+    niche_radius int //members closer than this share fitness
+}
 //for getting alignment score from biogo
 type Scorer interface {
     Score() int
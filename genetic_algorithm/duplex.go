@@ -0,0 +1,156 @@
+package main
+
+import(
+    "math"
+    "strings"
+)
+
+//Duplex hybridisation thermodynamics between a member and the target
+//nearest neighbour parameters are the unified set from SantaLucia 1998
+//salt correction uses the sodium equivalent of Mg2+ from von Ahsen et al. 2001
+
+const R_CAL = 1.9872 //gas constant in cal/(K mol)
+const KELVIN = 273.15
+const DUPLEX_SCALE = 10.0 //kcal/mol, dG at which the duplex score is 1-1/e
+
+//enthalpy (kcal/mol) and entropy (cal/(K mol)) of each Watson-Crick stack
+//keyed by the 5'->3' dinucleotide of the top strand
+var NN_ENTHALPY = map[string]float64{
+    "AA":-7.9, "TT":-7.9,
+    "AT":-7.2,
+    "TA":-7.2,
+    "CA":-8.5, "TG":-8.5,
+    "GT":-8.4, "AC":-8.4,
+    "CT":-7.8, "AG":-7.8,
+    "GA":-8.2, "TC":-8.2,
+    "CG":-10.6,
+    "GC":-9.8,
+    "GG":-8.0, "CC":-8.0,
+}
+var NN_ENTROPY = map[string]float64{
+    "AA":-22.2, "TT":-22.2,
+    "AT":-20.4,
+    "TA":-21.3,
+    "CA":-22.7, "TG":-22.7,
+    "GT":-22.4, "AC":-22.4,
+    "CT":-21.0, "AG":-21.0,
+    "GA":-22.2, "TC":-22.2,
+    "CG":-27.2,
+    "GC":-24.4,
+    "GG":-19.9, "CC":-19.9,
+}
+//helix initiation, depending on the terminal base pair
+var INIT_GC = [2]float64{0.1,-2.8}
+var INIT_AT = [2]float64{2.3,4.1}
+
+// Conditions are the buffer conditions used for duplex thermodynamics
+type Conditions struct {
+    na float64 //monovalent cations in mM
+    mg float64 //Mg2+ in mM
+    dntp float64 //dNTPs in mM, these chelate Mg2+
+    oligo float64 //total strand concentration in nM
+    temperature float64 //in C
+}
+// Duplex is the thermodynamics of the best binding register of a member on the target
+type Duplex struct {
+    dH float64 //kcal/mol
+    dS float64 //cal/(K mol), salt corrected
+    dG float64 //kcal/mol at the conditions temperature
+    tm float64 //melting temperature in C
+    start int //position of the helix in the member
+    length int //number of base pairs
+}
+
+// SodiumEquivalent() monovalent salt concentration equivalent to the buffer, in M
+func (c Conditions) SodiumEquivalent() float64 {
+    free_mg := math.Max(0,c.mg-c.dntp)
+    return (c.na + 120*math.Sqrt(free_mg))/1000
+}
+// ReverseComplement() reverse complement of a DNA string, N is kept
+func ReverseComplement(seq string) string {
+    rc := make([]byte,len(seq))
+    for i := range seq {
+        rc[len(seq)-1-i] = byte(DNA_COMPLEMENTS[rune(seq[i])])
+        if seq[i] == 'N' {
+            rc[len(seq)-1-i] = 'N'
+        }
+    }
+    return string(rc)
+}
+// HelixThermodynamics() nearest neighbour thermodynamics of a perfectly matched helix
+// input: top strand of the helix (the bottom strand is its reverse complement), buffer conditions
+// output: Duplex with dH, salt corrected dS, dG and Tm (start and length are not set)
+func HelixThermodynamics(helix string, c Conditions) Duplex {
+    var d Duplex
+    for _,end := range []byte{helix[0],helix[len(helix)-1]} {//initiation at each helix end
+        init := INIT_GC
+        if end == 'A' || end == 'T' {
+            init = INIT_AT
+        }
+        d.dH += init[0]
+        d.dS += init[1]
+    }
+    for i := 0; i < len(helix)-1; i++ {
+        d.dH += NN_ENTHALPY[helix[i:i+2]]
+        d.dS += NN_ENTROPY[helix[i:i+2]]
+    }
+    d.dS += 0.368*float64(len(helix)-1)*math.Log(c.SodiumEquivalent())
+    d.dG = d.dH - (c.temperature+KELVIN)*d.dS/1000
+    //non self complementary strands at equal concentration
+    d.tm = d.dH*1000/(d.dS + R_CAL*math.Log(c.oligo*1e-9/4)) - KELVIN
+    d.length = len(helix)
+    return d
+}
+// BestDuplex() finds the most stable helix formed between a member and the target
+// every ungapped antiparallel register is tried and each run of Watson-Crick pairs
+// in that register is a candidate helix, the one with the lowest dG is returned
+// input: member sequence, target sequence 5'->3' and the buffer conditions
+// output: Duplex of the best helix, dG is 0, length 0 and Tm NaN if no pair of bases can form
+func BestDuplex(seq string, target string, c Conditions) Duplex {
+    seq = strings.ToUpper(seq)
+    site := ReverseComplement(strings.ToUpper(target)) //a binding site must equal this
+    best := Duplex{tm:math.NaN()}
+    for offset := -len(seq)+1; offset < len(site); offset++ {//site position of seq[0]
+        start := -1 //start of the current run of matches
+        for i := 0; i <= len(seq); i++ {
+            p := i+offset
+            match := i < len(seq) && p >= 0 && p < len(site) && seq[i] == site[p]
+            if match && start < 0 {
+                start = i
+            } else if !match && start >= 0 {
+                if i-start >= 2 {//single pairs do not form a helix
+                    d := HelixThermodynamics(seq[start:i],c)
+                    if d.dG < best.dG {
+                        d.start = start
+                        best = d
+                    }
+                }
+                start = -1
+            }
+        }
+    }
+    return best
+}
+// Duplex() thermodynamics of the best binding register of a member on the target
+func (s Member) Duplex(target string, c Conditions) Duplex {
+    return BestDuplex(s.seq,target,c)
+}
+
+// DuplexTerm rewards stable hybridisation to the target
+// the score is 1-exp(dG/10), 0 for no binding and approaching 1 for very stable duplexes
+// dG and Tm are also recorded for every member as output columns, Tm is NA when no helix forms
+type DuplexTerm struct {
+    target string
+    conditions Conditions
+}
+func (t DuplexTerm) Name() string { return "duplex" }
+func (t DuplexTerm) Score(pop Population) []float64 {
+    scores := make([]float64,len(pop))
+    for i,member := range pop {
+        d := member.Duplex(t.target,t.conditions)
+        scores[i] = 1 - math.Exp(math.Min(0,d.dG)/DUPLEX_SCALE)
+        pop[i].Annotate("duplex_dG",d.dG)
+        pop[i].Annotate("duplex_Tm",d.tm)
+    }
+    return scores
+}
@@ -1,15 +1,18 @@
 package main
 
 import(
-    "os/exec"
+    "os"
+    "fmt"
+    "math"
+    "sort"
     "strconv"
     "strings"
+    "sync/atomic"
     "github.com/biogo/biogo/alphabet"
     "github.com/biogo/biogo/seq/linear"
 )
 
 const classifer_script = "../dnazyme_ML_model/dnazyme_classifier.py"
-const tmp_fasta = "/tmp/generation_tmp.fna"
 const python_exe = "/home/sidreed/anaconda3/envs/selexzyme/bin/python3"
 
 // Complementarity() returns BLAST score of sequence to target, higher is better for fitness
@@ -26,27 +29,207 @@ func (s Member) Complementarity(target *linear.Seq) float64 {
 }
 // CallDNAzymeModel() call a machine learning model to estimate
 // the likelihood  that this sequence is a DNAzyme
-func (pop Population) CallDNAzymeModel(model_file string) []float64 {
-    pop.WriteToFasta(tmp_fasta)
-    cmd := exec.Command(python_exe, classifer_script, tmp_fasta, model_file)
-    out, err := cmd.Output()
-    if err != nil {
-        panic(err)
+// the model is behind a ModelBackend, see model_backend.go
+func (pop Population) CallDNAzymeModel(backend ModelBackend) []float64 {
+    return backend.Predict(pop)
+}
+// GCContent() fraction of bases in the sequence that are G or C
+func (s Member) GCContent() float64 {
+    if len(s.seq) == 0 {
+        return 0
     }
-    output := strings.Split(strings.TrimSuffix(string(out),"\n")," ")
-    predictions := make([]float64,len(pop))
-    for i, prediction := range output {
-        predictions[i], _ = strconv.ParseFloat(prediction, 64) // return model probability as a float
+    gc := strings.Count(s.seq,"G") + strings.Count(s.seq,"C")
+    return float64(gc)/float64(len(s.seq))
+}
+
+//Fitness terms
+// ComplementarityTerm normalised SW score against the (complemented) target
+type ComplementarityTerm struct {
+    target *linear.Seq
+}
+func (t ComplementarityTerm) Name() string { return "complementarity" }
+func (t ComplementarityTerm) Score(pop Population) []float64 {
+    scores := make([]float64,len(pop))
+    for i,member := range pop {
+        scores[i] = member.Complementarity(t.target)
     }
-    return predictions
+    return scores
 }
-// ScoreFitness() asseses the total fitness every sequence in a population
-// output: no return, fitness is assigned for every seq inplace
-func (pop Population) ScoreFitness(target *linear.Seq, model_file string) {
-    predictions := pop.CallDNAzymeModel(model_file)
+// ClassifierTerm probability of being a DNAzyme according to the ML model
+type ClassifierTerm struct {
+    backend ModelBackend
+}
+func (t ClassifierTerm) Name() string { return "classifier" }
+func (t ClassifierTerm) Score(pop Population) []float64 {
+    return pop.CallDNAzymeModel(t.backend)
+}
+// ChunkSize() batches are sized by the backend
+func (t ClassifierTerm) ChunkSize() int { return t.backend.ChunkSize() }
+// GCTerm rewards balanced GC content, 1 at 50% GC and 0 at 0% or 100%
+type GCTerm struct {}
+func (t GCTerm) Name() string { return "gc" }
+func (t GCTerm) Score(pop Population) []float64 {
+    scores := make([]float64,len(pop))
     for i,member := range pop {
-        similarity := member.Complementarity(target)
-        dnazymeness := predictions[i]
-        pop[i].fitness = (similarity*0.4+dnazymeness*0.6)/2
+        scores[i] = 1 - 2*math.Abs(member.GCContent()-0.5)
+    }
+    return scores
+}
+
+// FITNESS_TERMS maps the names usable in -fitness to constructors of each term
+var FITNESS_TERMS = map[string]func(FitnessContext) FitnessTerm {
+    "complementarity": func(ctx FitnessContext) FitnessTerm { return ComplementarityTerm{target:ctx.target} },
+    "classifier": func(ctx FitnessContext) FitnessTerm { return ClassifierTerm{backend:NewModelBackend(ctx)} },
+    "gc": func(ctx FitnessContext) FitnessTerm { return GCTerm{} },
+    "fold": func(ctx FitnessContext) FitnessTerm { return FoldTerm{minHairpin:ctx.min_hairpin} },
+    "duplex": func(ctx FitnessContext) FitnessTerm { return DuplexTerm{target:ctx.target_seq,conditions:ctx.conditions} },
+    "offtarget": func(ctx FitnessContext) FitnessTerm {
+        if ctx.offtarget == nil {
+            panic("The offtarget fitness term needs a background fasta, see -background")
+        }
+        return OffTargetTerm{index:ctx.offtarget,target:ctx.target_seq,conditions:ctx.conditions,maxMismatches:ctx.offtarget_mismatches,template:ctx.template}
+    },
+}
+
+// ParseFitnessFunction() builds a fitness function from a spec like
+// "complementarity:0.4,classifier:0.6" or from a file with one name:weight per line
+// input: spec string or config file name, everything the terms need to score
+// output: FitnessFunction with the terms in the order given
+func ParseFitnessFunction(spec string, ctx FitnessContext) FitnessFunction {
+    if content, err := os.ReadFile(spec); err == nil {//spec is a config file
+        var lines []string
+        for _,line := range strings.Split(string(content),"\n") {
+            line = strings.TrimSpace(strings.Split(line,"#")[0]) //drop comments
+            if line != "" {
+                lines = append(lines,line)
+            }
+        }
+        spec = strings.Join(lines,",")
+    }
+    var fitness FitnessFunction
+    for _,field := range strings.Split(spec,",") {
+        parts := strings.Split(strings.TrimSpace(field),":")
+        if len(parts) != 2 {
+            panic(fmt.Sprintf("Invalid fitness term %q, must be name:weight",field))
+        }
+        newTerm, ok := FITNESS_TERMS[parts[0]]
+        if !ok {
+            panic(fmt.Sprintf("Unknown fitness term %q, must be one of {%s}",parts[0],strings.Join(FitnessTermNames(),"|")))
+        }
+        weight, err := strconv.ParseFloat(parts[1],64)
+        if err != nil || weight < 0 {
+            panic(fmt.Sprintf("Invalid weight for fitness term %q, must be a number >= 0",parts[0]))
+        }
+        fitness = append(fitness,WeightedTerm{term:newTerm(ctx),weight:weight})
+    }
+    return fitness
+}
+// FitnessTermNames() sorted names of every available fitness term
+func FitnessTermNames() []string {
+    var names []string
+    for name := range FITNESS_TERMS {
+        names = append(names,name)
+    }
+    sort.Strings(names)
+    return names
+}
+// Names() names of the terms in a fitness function, in order
+func (fitness FitnessFunction) Names() []string {
+    names := make([]string,len(fitness))
+    for i,wt := range fitness {
+        names[i] = wt.term.Name()
+    }
+    return names
+}
+// Objectives() names of the terms that contribute to fitness, those with weight > 0
+// terms with weight 0 are only scored for the output
+func (fitness FitnessFunction) Objectives() []string {
+    var names []string
+    for _,wt := range fitness {
+        if wt.weight > 0 {
+            names = append(names,wt.term.Name())
+        }
+    }
+    return names
+}
+// Annotate() records an extra value for a member that is written to the output
+func (s *Member) Annotate(name string, value float64) {
+    if s.annotations == nil {
+        s.annotations = make(map[string]float64)
+    }
+    s.annotations[name] = value
+}
+
+// PrepareContext() reads the target into the fitness context
+// members are aligned to the complement of the target as they must bind it
+// input: context with the other settings filled in and the target fasta file
+// output: copy of the context with the target set
+func PrepareContext(ctx FitnessContext, targetFile string) FitnessContext {
+    target := ReadTargetFromFasta(targetFile)
+    ctx.target_seq = SeqString(target)
+    target.RevComp()
+    target.Reverse() //no complement method so do reverse(reverse complement
+    ctx.target = target
+    return ctx
+}
+
+// ScoreFitness() asseses the total fitness every sequence in a population
+// sequences already in FITNESS_CACHE are not re-scored and every new
+// sequence is scored once, even if several members share it
+// output: no return, fitness and per term scores are assigned for every seq inplace
+func (pop Population) ScoreFitness(fitness FitnessFunction) {
+    if FITNESS_CACHE == nil {
+        pop.ComputeFitness(fitness)
+        return
+    }
+    results := make(map[string]CachedFitness)
+    var missing Population
+    pending := make(map[string]bool)
+    for _,member := range pop {
+        if _, ok := results[member.seq]; ok || pending[member.seq] {
+            continue
+        }
+        if entry, ok := FITNESS_CACHE.Get(member.seq); ok {
+            results[member.seq] = entry
+        } else {
+            pending[member.seq] = true
+            missing = append(missing,member)
+        }
+    }
+    missing.ComputeFitness(fitness)
+    for _,member := range missing {
+        entry := CachedFitness{Seq:member.seq,Fitness:member.fitness,Scores:member.scores,Annotations:member.annotations}
+        FITNESS_CACHE.Put(entry)
+        results[member.seq] = entry
+    }
+    for i := range pop {
+        entry := results[pop[i].seq]
+        pop[i].fitness = entry.Fitness
+        pop[i].scores = CopyScores(entry.Scores)
+        pop[i].annotations = CopyScores(entry.Annotations)
+    }
+}
+// ComputeFitness() scores every member of a population with the fitness function
+// each term is scored by the worker pool, see ScoreParallel()
+// fitness is the weighted sum of every term divided by the number of terms with weight > 0
+// the default complementarity:0.4,classifier:0.6 gives the original (0.4s+0.6p)/2
+// every member counts as one fitness evaluation towards an evals stop condition
+// output: no return, fitness and per term scores are assigned for every seq inplace
+func (pop Population) ComputeFitness(fitness FitnessFunction) {
+    atomic.AddInt64(&FITNESS_EVALUATIONS,int64(len(pop)))
+    for i := range pop {
+        pop[i].fitness = 0
+        pop[i].scores = make(map[string]float64,len(fitness))
+        pop[i].annotations = nil
+    }
+    for _,wt := range fitness {
+        scores := ScoreParallel(wt.term,pop)
+        for i := range pop {
+            pop[i].scores[wt.term.Name()] = scores[i]
+            pop[i].fitness += wt.weight*scores[i]
+        }
+    }
+    for i := range pop {
+        pop[i].fitness /= float64(Max(1,len(fitness.Objectives())))
     }
 }
@@ -0,0 +1,272 @@
+package main
+
+import(
+    "fmt"
+    "math"
+    "os"
+    "strings"
+)
+
+//Secondary structure prediction
+//Zuker style minimum free energy folding with DNA nearest neighbour parameters
+//energies are in kcal/mol at 37C, from SantaLucia & Hicks 2004
+//terminal mismatches, dangling ends and special hairpins are not modelled
+
+const MAX_LOOP = 30 //largest internal loop or bulge considered
+const RT_37 = 0.0019872*310.15 //gas constant * 37C in kcal/mol
+const TERMINAL_AT_PENALTY = 0.05 //per helix end closed by an A-T pair
+const MULTI_A = 3.4 //multiloop closing penalty
+const MULTI_B = 0.0 //multiloop penalty per unpaired base
+const MULTI_C = 0.4 //multiloop penalty per branching helix
+const ASYMMETRY_PENALTY = 0.3 //per base of internal loop asymmetry
+
+//Watson-Crick stacks keyed by the 5'->3' dinucleotide of the top strand
+var NN_STACKS = map[string]float64{
+    "AA":-1.00, "TT":-1.00,
+    "AT":-0.88,
+    "TA":-0.58,
+    "CA":-1.45, "TG":-1.45,
+    "GT":-1.44, "AC":-1.44,
+    "CT":-1.28, "AG":-1.28,
+    "GA":-1.30, "TC":-1.30,
+    "CG":-2.17,
+    "GC":-2.24,
+    "GG":-1.84, "CC":-1.84,
+}
+//loop initiation energies by loop size, other sizes are interpolated
+var HAIRPIN_LOOPS = map[int]float64{3:3.5, 4:3.5, 5:3.3, 6:4.0, 7:4.2, 8:4.3, 9:4.5, 10:4.6, 12:5.0, 14:5.1, 16:5.3, 18:5.5, 20:5.7, 25:6.1, 30:6.3}
+var BULGE_LOOPS = map[int]float64{1:4.0, 2:2.9, 3:3.1, 4:3.2, 5:3.3, 6:3.5, 7:3.7, 8:3.9, 9:4.1, 10:4.3, 12:4.5, 14:4.8, 16:5.0, 18:5.2, 20:5.3, 25:5.6, 30:5.9}
+var INTERIOR_LOOPS = map[int]float64{2:1.0, 3:3.2, 4:3.6, 5:4.0, 6:4.4, 7:4.6, 8:4.8, 9:4.9, 10:4.9, 12:5.2, 14:5.4, 16:5.6, 18:5.8, 20:5.9, 25:6.3, 30:6.6}
+
+// LoopInitiation() energy to initiate a loop of size n from a loop table
+// sizes missing from the table are linearly interpolated and loops
+// larger than the table use the Jacobson-Stockmayer extrapolation
+func LoopInitiation(table map[int]float64, n int) float64 {
+    if g, ok := table[n]; ok {
+        return g
+    }
+    low, high := 0, 0
+    for size := range table {
+        if size < n && size > low {
+            low = size
+        }
+        if size > n && (high == 0 || size < high) {
+            high = size
+        }
+    }
+    switch {
+        case low == 0: //smaller than any loop in the table, not allowed
+            return INF
+        case high == 0: //larger than any loop in the table
+            return table[low] + 1.75*RT_37*math.Log(float64(n)/float64(low))
+        default:
+            return table[low] + (table[high]-table[low])*float64(n-low)/float64(high-low)
+    }
+}
+// CanPair() true if the 2 bases form a Watson-Crick pair
+func CanPair(a,b byte) bool {
+    return DNA_COMPLEMENTS[rune(a)] == rune(b)
+}
+// ATPenalty() penalty for a helix end closed by an A-T pair
+func ATPenalty(a,b byte) float64 {
+    if a == 'A' || a == 'T' {
+        return TERMINAL_AT_PENALTY
+    }
+    return 0
+}
+
+// Folder holds the dynamic programming tables for folding one sequence
+type Folder struct {
+    seq string
+    minHairpin int
+    V [][]float64 //energy of the best structure on i..j where i,j pair
+    WM [][]float64 //energy of i..j inside a multiloop
+    W []float64 //energy of the best structure on the prefix of length j
+}
+
+// Hairpin() energy of a hairpin loop closed by the pair i,j
+func (f *Folder) Hairpin(i,j int) float64 {
+    n := j-i-1
+    if n < f.minHairpin {
+        return INF
+    }
+    return LoopInitiation(HAIRPIN_LOOPS,n) + ATPenalty(f.seq[i],f.seq[j])
+}
+// InternalLoop() energy of a stack, bulge or interior loop closed by
+// the outer pair i,j and the inner pair k,l
+func (f *Folder) InternalLoop(i,j,k,l int) float64 {
+    n1 := k-i-1
+    n2 := j-l-1
+    switch {
+        case n1 == 0 && n2 == 0: //stacked pairs
+            return NN_STACKS[f.seq[i:i+2]]
+        case n1 == 0 || n2 == 0: //bulge
+            if n1+n2 == 1 {//single bulges keep the stack of the adjacent pairs
+                return LoopInitiation(BULGE_LOOPS,1) + NN_STACKS[string([]byte{f.seq[i],f.seq[k]})]
+            }
+            return LoopInitiation(BULGE_LOOPS,n1+n2) + ATPenalty(f.seq[i],f.seq[j]) + ATPenalty(f.seq[k],f.seq[l])
+        default: //interior loop
+            asymmetry := ASYMMETRY_PENALTY*math.Abs(float64(n1-n2))
+            return LoopInitiation(INTERIOR_LOOPS,n1+n2) + asymmetry + ATPenalty(f.seq[i],f.seq[j]) + ATPenalty(f.seq[k],f.seq[l])
+    }
+}
+// MultiLoop() energy of the best multiloop closed by the pair i,j and the split point used
+func (f *Folder) MultiLoop(i,j int) (float64,int) {
+    best, split := INF, -1
+    for u := i+2; u < j-1; u++ {
+        e := f.WM[i+1][u] + f.WM[u+1][j-1]
+        if e < best {
+            best, split = e, u
+        }
+    }
+    return best + MULTI_A + MULTI_C + ATPenalty(f.seq[i],f.seq[j]), split
+}
+
+// FoldSequence() predicts the minimum free energy secondary structure of a DNA sequence
+// input: DNA string and the minimum number of unpaired bases in a hairpin loop
+// output: MFE in kcal/mol (0 if unfolded) and the structure in dot-bracket notation
+func FoldSequence(seq string, minHairpin int) (float64,string) {
+    seq = strings.ToUpper(seq)
+    n := len(seq)
+    f := &Folder{seq:seq,minHairpin:Max(minHairpin,3)}
+    f.V = make([][]float64,n)
+    f.WM = make([][]float64,n)
+    for i := range f.V {
+        f.V[i] = make([]float64,n)
+        f.WM[i] = make([]float64,n)
+        for j := range f.V[i] {
+            f.V[i][j], f.WM[i][j] = INF, INF
+        }
+    }
+    for span := f.minHairpin+1; span < n; span++ {//fill by increasing i..j distance
+        for i := 0; i+span < n; i++ {
+            j := i+span
+            if CanPair(seq[i],seq[j]) {
+                best := f.Hairpin(i,j)
+                for k := i+1; k < j && k-i-1 <= MAX_LOOP; k++ {
+                    for l := j-1; l > k && (k-i-1)+(j-l-1) <= MAX_LOOP; l-- {
+                        if f.V[k][l] < INF {
+                            best = math.Min(best,f.InternalLoop(i,j,k,l)+f.V[k][l])
+                        }
+                    }
+                }
+                multi, _ := f.MultiLoop(i,j)
+                f.V[i][j] = math.Min(best,multi)
+            }
+            wm := math.Min(f.WM[i+1][j]+MULTI_B,f.WM[i][j-1]+MULTI_B)
+            wm = math.Min(wm,f.V[i][j]+MULTI_C+ATPenalty(seq[i],seq[j]))
+            for u := i+1; u < j; u++ {
+                wm = math.Min(wm,f.WM[i][u]+f.WM[u+1][j])
+            }
+            f.WM[i][j] = wm
+        }
+    }
+    f.W = make([]float64,n+1)
+    for j := 1; j <= n; j++ {//exterior loop, no penalty for unpaired bases
+        f.W[j] = f.W[j-1]
+        for i := 0; i < j-1; i++ {
+            if f.V[i][j-1] < INF {
+                f.W[j] = math.Min(f.W[j],f.W[i]+f.V[i][j-1]+ATPenalty(seq[i],seq[j-1]))
+            }
+        }
+    }
+    structure := []byte(strings.Repeat(".",n))
+    f.TraceExterior(n,structure)
+    return f.W[n], string(structure)
+}
+
+// Equal() compare energies from the dp tables allowing for rounding
+func Equal(a,b float64) bool {
+    return math.Abs(a-b) < 1e-9
+}
+// TraceExterior() fills in the structure of the prefix of length j
+func (f *Folder) TraceExterior(j int, structure []byte) {
+    for j > 0 {
+        next := j-1 //j-1 is unpaired unless a pair explains W[j]
+        if !Equal(f.W[j],f.W[j-1]) {
+            for i := 0; i < j-1; i++ {
+                if f.V[i][j-1] < INF && Equal(f.W[j],f.W[i]+f.V[i][j-1]+ATPenalty(f.seq[i],f.seq[j-1])) {
+                    f.TracePair(i,j-1,structure)
+                    next = i
+                    break
+                }
+            }
+        }
+        j = next
+    }
+}
+// TracePair() fills in the structure closed by the pair i,j
+func (f *Folder) TracePair(i,j int, structure []byte) {
+    structure[i], structure[j] = '(', ')'
+    e := f.V[i][j]
+    if Equal(e,f.Hairpin(i,j)) {
+        return
+    }
+    for k := i+1; k < j && k-i-1 <= MAX_LOOP; k++ {
+        for l := j-1; l > k && (k-i-1)+(j-l-1) <= MAX_LOOP; l-- {
+            if f.V[k][l] < INF && Equal(e,f.InternalLoop(i,j,k,l)+f.V[k][l]) {
+                f.TracePair(k,l,structure)
+                return
+            }
+        }
+    }
+    _, u := f.MultiLoop(i,j)
+    f.TraceMulti(i+1,u,structure)
+    f.TraceMulti(u+1,j-1,structure)
+}
+// TraceMulti() fills in the structure of i..j inside a multiloop
+func (f *Folder) TraceMulti(i,j int, structure []byte) {
+    e := f.WM[i][j]
+    switch {
+        case f.V[i][j] < INF && Equal(e,f.V[i][j]+MULTI_C+ATPenalty(f.seq[i],f.seq[j])):
+            f.TracePair(i,j,structure)
+        case i < j && Equal(e,f.WM[i+1][j]+MULTI_B):
+            f.TraceMulti(i+1,j,structure)
+        case i < j && Equal(e,f.WM[i][j-1]+MULTI_B):
+            f.TraceMulti(i,j-1,structure)
+        default:
+            for u := i+1; u < j; u++ {
+                if Equal(e,f.WM[i][u]+f.WM[u+1][j]) {
+                    f.TraceMulti(i,u,structure)
+                    f.TraceMulti(u+1,j,structure)
+                    return
+                }
+            }
+    }
+}
+
+// Fold() MFE and dot-bracket structure of a member
+func (s Member) Fold(minHairpin int) (float64,string) {
+    return FoldSequence(s.seq,minHairpin)
+}
+// FoldTerm penalises members that fold onto themselves instead of binding the target
+// the score is 1/(1+|MFE|) for stable structures, so 1 means no stable structure
+type FoldTerm struct {
+    minHairpin int
+}
+func (t FoldTerm) Name() string { return "fold" }
+func (t FoldTerm) Score(pop Population) []float64 {
+    scores := make([]float64,len(pop))
+    for i,member := range pop {
+        mfe, _ := member.Fold(t.minHairpin)
+        scores[i] = 1/(1+math.Max(0,-mfe))
+    }
+    return scores
+}
+
+// WriteFoldTSV() folds every member of a population and writes the MFE and structure
+// input: population, output file name and the minimum hairpin loop size
+// output: no return, writes a tsv with the columns Header,Sequence,MFE,Structure
+func (pop Population) WriteFoldTSV(filename string, minHairpin int) {
+    outfile,err := os.Create(filename)
+    if err != nil {
+        panic(err)
+    }
+    defer outfile.Close()
+    outfile.WriteString("Header\tSequence\tMFE\tStructure\n")
+    for _,member := range pop {
+        mfe, structure := member.Fold(minHairpin)
+        line := fmt.Sprintf("%s\t%s\t%.2f\t%s\n",member.header,member.seq,mfe,structure)
+        outfile.WriteString(line)
+    }
+}
@@ -0,0 +1,214 @@
+package main
+
+import(
+    "os"
+    "fmt"
+    "math"
+    "sort"
+    "sync"
+    "time"
+    "bufio"
+    "strconv"
+    "strings"
+    "path/filepath"
+    "sync/atomic"
+    "encoding/json"
+)
+
+//Generation history
+//with -history file.tsv (or file.jsonl) the statistics of every generation of every island are
+//written as the run goes, one row (or json object) per generation
+//  island, generation, seconds since the start and fitness evaluations so far
+//  min, quartiles, mean, max, std. dev and CoV of fitness, as Summarize() prints them
+//  mean_<term> the mean score of every fitness term
+//  entropy (see Entropy()), mean_distance the mean Hamming distance between members,
+//  unique sequences and mean length
+//  mutation_rate the rate the generation was bred with by the schedule, the last rate for the final generation
+//when a run is resumed, rows from after its checkpoint are dropped and the history continues
+
+var HISTORY_FORMATS = []string{".tsv",".jsonl"}
+
+// History writes a row of statistics for every generation
+type History struct {
+    outfile *os.File
+    jsonl bool
+    columns []string //names of the values in every row, written as the tsv header
+    start time.Time //start of the run
+    lock sync.Mutex //islands record in parallel
+}
+
+// OpenHistory() opens the history file, nil if filename is ""
+// input: file name, start of the run and the checkpoint the run resumes from (nil for a new run)
+// output: pointer to the History, panics if the extension is not one of HISTORY_FORMATS
+func OpenHistory(filename string, start time.Time, resume *Checkpoint) *History {
+    if len(filename) == 0 {
+        return nil
+    }
+    if !InList(filepath.Ext(filename),HISTORY_FORMATS) {
+        panic("Invalid history file " + filename + ", must have extension {" + strings.Join(HISTORY_FORMATS,"|") + "}")
+    }
+    h := &History{jsonl:filepath.Ext(filename) == ".jsonl",start:start}
+    var kept []string
+    if resume != nil {
+        kept = h.Truncate(filename,resume)
+    }
+    outfile, err := os.Create(filename)
+    if err != nil { panic(err) }
+    for _,line := range kept {
+        outfile.WriteString(line + "\n")
+    }
+    h.outfile = outfile
+    return h
+}
+// Truncate() rows of an earlier history written before the checkpoint, the tsv header first
+// input: history file name and the checkpoint, a missing file has no rows
+// output: lines to keep, the columns are taken from the header
+func (h *History) Truncate(filename string, checkpoint *Checkpoint) []string {
+    infile, err := os.Open(filename)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil { panic(err) }
+    defer infile.Close()
+    var kept []string
+    scanner := bufio.NewScanner(infile)
+    scanner.Buffer(make([]byte,1<<16),1<<26)
+    for scanner.Scan() {
+        line := scanner.Text()
+        var island, gen int
+        if h.jsonl {
+            var row struct {
+                Island int `json:"island"`
+                Generation int `json:"generation"`
+            }
+            if err := json.Unmarshal([]byte(line),&row); err != nil { panic(err) }
+            island, gen = row.Island, row.Generation
+        } else if h.columns == nil {//header
+            h.columns = strings.Split(line,"\t")
+            kept = append(kept,line)
+            continue
+        } else {
+            fields := strings.Split(line,"\t")
+            island, _ = strconv.Atoi(fields[0])
+            gen, _ = strconv.Atoi(fields[1])
+        }
+        if island < len(checkpoint.Islands) && gen < checkpoint.Islands[island].Gen {
+            kept = append(kept,line)
+        }
+    }
+    if err := scanner.Err(); err != nil { panic(err) }
+    return kept
+}
+// Record() writes the statistics of the island's current generation
+func (h *History) Record(island *Island) {
+    if h == nil {
+        return
+    }
+    names := []string{"island","generation","seconds","evaluations"}
+    values := []float64{float64(island.id),
+                        float64(island.gen),
+                        time.Since(h.start).Seconds(),
+                        float64(atomic.LoadInt64(&FITNESS_EVALUATIONS)),
+                       }
+    statNames, stats := island.population.Statistics()
+    names = append(append(names,statNames...),"mutation_rate")
+    values = append(append(values,stats...),island.breeder.mutation_rate)
+    h.lock.Lock()
+    defer h.lock.Unlock()
+    var line string
+    if h.jsonl {
+        fields := make([]string,len(names))
+        for i,name := range names {
+            fields[i] = strconv.Quote(name) + ":" + FormatValue(values[i],"null")
+        }
+        line = "{" + strings.Join(fields,",") + "}"
+    } else {
+        if h.columns == nil {
+            h.columns = names
+            h.outfile.WriteString(strings.Join(names,"\t") + "\n")
+        }
+        fields := make([]string,len(values))
+        for i,value := range values {
+            fields[i] = FormatValue(value,"NA")
+        }
+        line = strings.Join(fields,"\t")
+    }
+    if _, err := h.outfile.WriteString(line + "\n"); err != nil { panic(err) }
+}
+// Close() closes the history file
+func (h *History) Close() {
+    if h == nil {
+        return
+    }
+    if err := h.outfile.Close(); err != nil { panic(err) }
+    fmt.Println("Generation history written to ",h.outfile.Name())
+}
+// FormatValue() shortest exact form of a value, missing for NaN and Inf
+func FormatValue(value float64, missing string) string {
+    if math.IsNaN(value) || math.IsInf(value,0) {
+        return missing
+    }
+    return strconv.FormatFloat(value,'g',-1,64)
+}
+
+// Statistics() fitness, fitness term, diversity and length statistics of a population
+// the population is left in its order
+// output: names and values of the statistics
+func (pop Population) Statistics() ([]string, []float64) {
+    fitnesses := pop.FitnessList()
+    sort.Float64s(fitnesses)
+    names := []string{"min","q1","mean","q3","max","std_dev","cov"}
+    values := []float64{fitnesses[0],
+                        fitnesses[len(fitnesses)/4],
+                        Mean(fitnesses),
+                        fitnesses[3*len(fitnesses)/4],
+                        fitnesses[len(fitnesses)-1],
+                        StdDev(fitnesses),
+                        CoV(fitnesses),
+                       }
+    for _,term := range pop.TermNames() {
+        scores := make([]float64,len(pop))
+        for i,member := range pop {
+            scores[i] = member.scores[term]
+        }
+        names = append(names,"mean_"+term)
+        values = append(values,Mean(scores))
+    }
+    unique := make(map[string]bool,len(pop))
+    lengths := make([]float64,len(pop))
+    for i,member := range pop {
+        unique[member.seq] = true
+        lengths[i] = float64(len(member.seq))
+    }
+    names = append(names,"entropy","mean_distance","unique","mean_length")
+    values = append(values,pop.Entropy(),pop.MeanDistance(),float64(len(unique)),Mean(lengths))
+    return names, values
+}
+// MeanDistance() mean Hamming distance between every pair of members, see HammingDistance()
+// counted from the bases at each position, so it takes time linear in the population size
+func (pop Population) MeanDistance() float64 {
+    n := float64(len(pop))
+    if n < 2 {
+        return 0
+    }
+    total := 0.0
+    for p := 0; ; p++ {
+        var counts [256]float64 //by base
+        present := 0.0
+        for _,member := range pop {
+            if p < len(member.seq) {
+                counts[member.seq[p]]++
+                present++
+            }
+        }
+        if present == 0 {
+            break
+        }
+        same := 0.0
+        for _,count := range counts {
+            same += count*count
+        }
+        total += (present*present - same)/2 + present*(n-present) //pairs with different bases, or one longer
+    }
+    return total/(n*(n-1)/2)
+}
@@ -0,0 +1,276 @@
+package main
+
+import(
+    "os"
+    "fmt"
+    "sort"
+    "bufio"
+    "strings"
+    "strconv"
+    "math/rand"
+    "github.com/cheggaaa/pb"
+)
+
+//Island model
+//-islands N independent populations evolve in parallel goroutines, every -migration_interval
+//generations each island sends copies of its -migrants fittest members to other islands where
+//they replace the least fit members, the islands a migrant goes to depend on -topology
+//  ring    to the next island, the last sends to the first
+//  full    to every other island
+//  random  to one other island picked at random at each migration
+//islands breed with the same settings unless -island_config gives settings for each island
+//every island draws its random numbers from its own generator, see random.go
+
+var TOPOLOGIES = []string{"ring","full","random"}
+
+// Migration holds the island model settings
+type Migration struct {
+    interval int //generations between migrations
+    migrants int //fittest members sent by each island
+    topology string //one of TOPOLOGIES
+}
+// Island is one population evolving with its own breeding settings
+type Island struct {
+    id int
+    breeder Breeder
+    population Population
+    gen int //generations bred
+    state StopState //fitness of the last generations, for the stop conditions
+    stop string //why the island stopped early, empty if it did not
+    prefix string //label in logs, empty for a single population
+    source *CountingSource //the island's random numbers, seeded by IslandSeed()
+    rng *rand.Rand
+}
+// NewIsland() an island breeding with the breeder, drawing random numbers from its own source
+// input: island id, breeding settings and the seed of the island's random numbers
+func NewIsland(id int, breeder Breeder, seed int64) *Island {
+    source := NewCountingSource(seed)
+    return &Island{id:id,breeder:breeder,source:source,rng:rand.New(source)}
+}
+
+// IslandBreeders() breeding settings of every island
+// each line of the config file sets one island (in order) as space separated key=value pairs,
+// keys are the flag names
+//   selection tournament_size selection_pressure top_seqs crossover crossover_rate
+//   mutation indel mutation_model mutation-schedule niching niche_distance niche_radius
+// settings that are not given keep their flag value, blank lines and anything after # are ignored
+// islands past the last line reuse the lines from the first
+// input: breeding settings from the flags, config file name ("" for none) and number of islands
+// output: breeding settings of each island, each with its own mutation schedule
+// panics if an island's mutation rate does not suit its schedule
+func IslandBreeders(base Breeder, config string, islands int) []Breeder {
+    var lines []string
+    if len(config) != 0 {
+        configFile, err := os.Open(config)
+        if err != nil { panic(err) }
+        defer configFile.Close()
+        scanner := bufio.NewScanner(configFile)
+        for scanner.Scan() {
+            if line := strings.TrimSpace(strings.Split(scanner.Text(),"#")[0]); len(line) != 0 {
+                lines = append(lines,line)
+            }
+        }
+        if err := scanner.Err(); err != nil { panic(err) }
+    }
+    breeders := make([]Breeder,islands)
+    for i := range breeders {
+        breeder := base
+        schedule := *base.schedule
+        breeder.schedule = &schedule //schedules keep state, one per island
+        if len(lines) != 0 {
+            breeder.Configure(lines[i%len(lines)])
+            if err := breeder.schedule.Check(); err != nil {//the island's own rate and schedule
+                panic(fmt.Sprintf("Invalid settings for island %d (%s): %s",i,lines[i%len(lines)],err))
+            }
+        }
+        breeders[i] = breeder
+    }
+    return breeders
+}
+// Configure() overrides breeding settings with key=value pairs from an island config line
+// panics on an unknown key or an invalid value
+func (breeder *Breeder) Configure(line string) {
+    for _,field := range strings.Fields(line) {
+        pair := strings.SplitN(field,"=",2)
+        if len(pair) != 2 {
+            panic(fmt.Sprintf("Invalid island setting %q, must be key=value",field))
+        }
+        key, value := pair[0], pair[1]
+        number, err := strconv.ParseFloat(value,64)
+        invalid := fmt.Sprintf("Invalid island setting %q",field)
+        switch {
+            case key == "selection" && InList(value,SELECTIONS):
+                breeder.selection = value
+            case key == "tournament_size" && err == nil && number >= 1:
+                breeder.tournament_size = int(number)
+            case key == "selection_pressure" && err == nil && Between(number,1,2):
+                breeder.selection_pressure = number
+            case key == "top_seqs" && err == nil && Between(number,0,1):
+                breeder.top_sequence_percent = number
+            case key == "crossover" && CROSSOVERS[value] != nil:
+                breeder.crossover = value
+            case key == "crossover_rate" && err == nil && Between(number,0,1):
+                breeder.crossover_rate = number
+            case key == "mutation" && err == nil && Between(number,0,1):
+                breeder.mutation_rate = number
+                breeder.schedule.initial = number
+                breeder.schedule.rate = number
+            case key == "indel" && err == nil && Between(number,0,1):
+                breeder.indel_rate = number
+            case key == "mutation_model":
+                breeder.mutation_model = ParseMutationModel(value)
+            case key == "mutation-schedule" && InList(value,MUTATION_SCHEDULES):
+                breeder.schedule.mode = value
+            case key == "niching" && InList(value,NICHINGS):
+                breeder.niching = value
+            case key == "niche_distance" && InList(value,NICHE_DISTANCES):
+                breeder.niche_distance = value
+            case key == "niche_radius" && err == nil && number >= 1:
+                breeder.niche_radius = int(number)
+            default:
+                panic(invalid)
+        }
+    }
+}
+
+// Evolve() breeds the island's population until generation until, its stop rule is met or the run is interrupted
+// input: last generation to breed, fitness function, stop rule, generation history (nil for none),
+// whether to log every generation and the progress bar (nil when logging)
+func (island *Island) Evolve(until int,
+                             fitness FitnessFunction,
+                             stopper Stopper,
+                             history *History,
+                             verbose bool,
+                             bar *pb.ProgressBar) {
+    for ; len(island.stop) == 0 && island.gen < until; island.gen++ {
+        if Interrupted() {
+            island.stop = "interrupted"
+            break
+        }
+        island.state.Observe(island.population,stopper.plateau_gens)
+        //check if the stop rule is met, e.g. average fitness has plateaued
+        if stop, reason := stopper.Stop(&island.state); stop {
+            island.stop = reason
+            break //no improvements wanted from continuing simulation, finish
+        }
+        island.breeder.mutation_rate = island.breeder.schedule.Update(island.gen,island.population)
+        history.Record(island)
+        if verbose {
+            island.population.LogGeneration(island.prefix,island.gen,island.breeder.mutation_rate)
+        }
+        island.population = BreedNewGeneration(island.rng,island.population,fitness,island.breeder)
+        if bar != nil {
+            bar.Increment()
+        }
+    }
+}
+// Migrate() copies the fittest members of every island to its destinations
+// emigrants are picked from every island before any arrive
+// input: islands and the island model settings
+func Migrate(islands []*Island, migration Migration) {
+    emigrants := make([]Population,len(islands))
+    for i,island := range islands {
+        emigrants[i] = TopMembers(island.population,migration.migrants)
+    }
+    arrivals := make([]Population,len(islands))
+    for i := range islands {
+        for _,j := range Destinations(i,len(islands),migration.topology) {
+            arrivals[j] = append(arrivals[j],emigrants[i]...)
+        }
+    }
+    for j,island := range islands {
+        island.Receive(arrivals[j])
+    }
+}
+// Destinations() islands that island i sends migrants to
+func Destinations(i,islands int, topology string) []int {
+    if islands < 2 {
+        return []int{}
+    }
+    switch topology {
+        case "ring":
+            return []int{(i+1)%islands}
+        case "full":
+            var destinations []int
+            for j := 0; j < islands; j++ {
+                if j != i {
+                    destinations = append(destinations,j)
+                }
+            }
+            return destinations
+        case "random":
+            j := RNG.Intn(islands-1)
+            if j >= i {//skip the island itself
+                j++
+            }
+            return []int{j}
+        default:
+            panic("Invalid topology, must be one of {ring|full|random}")
+    }
+}
+// Receive() replaces the least fit members with migrants, at most half the population
+func (island *Island) Receive(migrants Population) {
+    if len(migrants) == 0 {
+        return
+    }
+    migrants = TopMembers(migrants,len(island.population)/2)
+    island.population.SortByFitness() //least fit first
+    for i,migrant := range migrants {
+        island.population[i] = migrant.Survivor(island.population[i].label)
+    }
+}
+// TopMembers() copy of the n fittest members, fittest first
+func TopMembers(pop Population, n int) Population {
+    sorted := make(Population,len(pop))
+    copy(sorted,pop)
+    sort.SliceStable(sorted,func(i,j int) bool { return sorted[i].fitness > sorted[j].fitness })
+    return sorted[:Min(n,len(sorted))]
+}
+
+// MergeIslands() every member of every island in one population
+func MergeIslands(islands []*Island) Population {
+    var merged Population
+    for _,island := range islands {
+        merged = append(merged,island.population...)
+    }
+    for i := range merged {
+        merged[i].label = i
+    }
+    return merged
+}
+// SummarizeIslands() prints and writes a tsv with the final state of every island
+// input: islands and the output file name of the summary
+func SummarizeIslands(islands []*Island, filename string) {
+    outfile, err := os.Create(filename)
+    if err != nil { panic(err) }
+    defer outfile.Close()
+    header := "Island\tGenerations\tStop\tMean\tMax\tUnique\tSelection\tCrossover\tMutationModel\tMutationRate\tNiching"
+    outfile.WriteString(header + "\n")
+    fmt.Println("Island Summary")
+    fmt.Println(header)
+    for _,island := range islands {
+        fitnesses := island.population.FitnessList()
+        line := fmt.Sprintf("%d\t%d\t%s\t%f\t%f\t%d\t%s\t%s\t%s\t%g\t%s",
+                            island.id,island.gen,island.StopReason(),Mean(fitnesses),MaxFloat(fitnesses),len(island.population.Collapse()),
+                            island.breeder.selection,island.breeder.crossover,island.breeder.mutation_model.name,
+                            island.breeder.mutation_rate,island.breeder.niching)
+        outfile.WriteString(line + "\n")
+        fmt.Println(line)
+    }
+}
+// StopReason() why the island stopped, max_iterations if it never met the stop rule
+func (island *Island) StopReason() string {
+    if len(island.stop) == 0 {
+        return "max_iterations"
+    }
+    return island.stop
+}
+// AllStopped() whether every island stopped early on its stop rule
+func AllStopped(islands []*Island) bool {
+    for _,island := range islands {
+        if len(island.stop) == 0 {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,29 @@
+package main
+
+import(
+    "os"
+    "testing"
+    "path/filepath"
+)
+
+// IslandConfig() writes an island config file for a test
+func IslandConfig(t *testing.T, content string) string {
+    config := filepath.Join(t.TempDir(),"islands.txt")
+    if err := os.WriteFile(config,[]byte(content),0644); err != nil { t.Fatal(err) }
+    return config
+}
+
+// TestIslandBreedersSchedule checks each island's mutation rate is checked against its own schedule
+func TestIslandBreedersSchedule(t *testing.T) {
+    base := Breeder{mutation_rate:0.1,schedule:NewMutationSchedule("constant",0.1,0.01,0.5,100)}
+    breeders := IslandBreeders(base,IslandConfig(t,"mutation=0.2 mutation-schedule=exponential\nmutation=0\n"),2)
+    if breeders[0].schedule.mode != "exponential" || breeders[0].schedule.initial != 0.2 || breeders[1].schedule.mode != "constant" {
+        t.Errorf("island schedules %+v and %+v",*breeders[0].schedule,*breeders[1].schedule)
+    }
+    ExpectPanic(t,"island 1",func() {
+        IslandBreeders(base,IslandConfig(t,"mutation=0.2\nmutation=0 mutation-schedule=exponential\n"),2)
+    })
+    ExpectPanic(t,"max mutation rate",func() {
+        IslandBreeders(base,IslandConfig(t,"mutation=0.8 mutation-schedule=diversity\n"),1)
+    })
+}
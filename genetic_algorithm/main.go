@@ -4,8 +4,10 @@ import(
     "os"
     "fmt"
     "flag"
+    "runtime"
+    "time"
     "strings"
-    "math/rand"
+    "path/filepath"
 )
 
 // Between() check if target is between max and min inclusive
@@ -25,9 +27,31 @@ func CheckParams(lower int,
                  maxIterations int,
                  targetFile string,
                  mutation_rate float64,
+                 mutation_schedule string,
+                 mutation_final float64,
+                 mutation_max float64,
                  top_sequence_percent float64,
                  fitness_plateau_tolerance float64,
                  fitness_plateau_generations int,
+                 selection string,
+                 crossover string,
+                 crossover_rate float64,
+                 tournament_size int,
+                 selection_pressure float64,
+                 niching string,
+                 niche_distance string,
+                 niche_radius int,
+                 islands int,
+                 migration_interval int,
+                 migrants int,
+                 topology string,
+                 seed_fraction float64,
+                 seed_mutation float64,
+                 mode string,
+                 selex SELEX,
+                 checkpoint_every int,
+                 history string,
+                 workers int,
                  outputfile string) {
     /* Parameter Restrictions
     - All numerical values must be positive
@@ -67,6 +91,51 @@ func CheckParams(lower int,
             panic("top_sequence_Percent must be in [0,1]")
         case fitness_plateau_generations < maxIterations:
             panic("generatoins to consider for fitness plateau must be < maxIterations")
+        case InList(selection,SELECTIONS):
+            panic("selection must be one of {"+strings.Join(SELECTIONS,"|")+"}")
+        case CROSSOVERS[crossover] != nil:
+            panic("crossover must be one of {"+strings.Join(CrossoverNames(),"|")+"}")
+        case Between(crossover_rate,0,1):
+            panic("crossover rate must be in [0,1]")
+        case tournament_size >= 1:
+            panic("tournament size must be >= 1")
+        case Between(selection_pressure,1,2):
+            panic("selection pressure must be in [1,2]")
+        case InList(niching,NICHINGS):
+            panic("niching must be one of {"+strings.Join(NICHINGS,"|")+"}")
+        case InList(niche_distance,NICHE_DISTANCES):
+            panic("niche distance must be one of {"+strings.Join(NICHE_DISTANCES,"|")+"}")
+        case niche_radius >= 1:
+            panic("niche radius must be >= 1")
+        case islands >= 1:
+            panic("islands must be >= 1")
+        case migration_interval >= 1:
+            panic("migration interval must be >= 1")
+        case migrants >= 0:
+            panic("migrants must be >= 0")
+        case InList(topology,TOPOLOGIES):
+            panic("topology must be one of {"+strings.Join(TOPOLOGIES,"|")+"}")
+        case Between(seed_fraction,0,1):
+            panic("seed fraction must be in [0,1]")
+        case Between(seed_mutation,0,1):
+            panic("seed mutation rate must be in [0,1]")
+        case InList(mode,MODES):
+            panic("mode must be one of {"+strings.Join(MODES,"|")+"}")
+        case selex.rounds >= 1 && selex.pool >= 1 && selex.pcr_cycles >= 0 && selex.top >= 0:
+            panic("selex rounds and pool must be >= 1, pcr cycles and selex top >= 0")
+        case checkpoint_every >= 0:
+            panic("checkpoint interval must be >= 0")
+        case len(history) == 0 || InList(filepath.Ext(history),HISTORY_FORMATS):
+            panic("history file must have extension {"+strings.Join(HISTORY_FORMATS,"|")+"}")
+        case selex.stringency > 0:
+            panic("stringency must be > 0")
+        case Between(selex.carryover,0,1) && Between(selex.pcr_efficiency,0,1) && Between(selex.pcr_bias,0,1) && Between(selex.pcr_error,0,1):
+            panic("carryover, pcr efficiency, pcr bias and pcr error must be in [0,1]")
+        case workers >= 1:
+            panic("workers must be >= 1")
+    }
+    if err := NewMutationSchedule(mutation_schedule,mutation_rate,mutation_final,mutation_max,maxIterations).Check(); err != nil {
+        panic(err.Error())
     }
 }
 
@@ -79,59 +148,310 @@ func main() {
     maxIterations := flag.Int("maxIters",30,"max generations to simulate")
     targetFastaFile := flag.String("target","target.fna","target sequence for generated dnazymes to catalyze")
     seed := flag.Int64("seed",0,"random seed, only set explicitly if specified")
+    base_freqs := flag.String("base_freqs","","relative frequencies A,C,G,T of the bases of random initial sequences, e.g. 0.3,0.2,0.2,0.3, default uniform")
+    gc_range := flag.String("gc_range","","GC content range MIN-MAX of random initial sequences, e.g. 0.4-0.6, default any")
+    length_dist := flag.String("length_dist","uniform","length distribution of random initial sequences, one of {uniform|normal} or a fasta file to draw lengths from")
+    length_sd := flag.Float64("length_sd",0,"standard deviation of -length_dist normal, default a quarter of upper-lower")
+    max_homopolymer := flag.Int("max_homopolymer",0,"longest run of one base allowed in random initial sequences, 0 for no limit")
+    seed_pop := flag.String("seed-pop","","fasta of known sequences (e.g. ../data/NCBI_DNAzymes.fasta or earlier hits) to start the initial population from, default only random sequences")
+    seed_fraction := flag.Float64("seed_fraction",1,"fraction of the initial population taken from -seed-pop, the rest is random, in [0,1]")
+    seed_mutation := flag.Float64("seed_mutation",0.05,"mutation rate of the seed variants filling up the seeded members when there are fewer seeds, 0 for exact copies, in [0,1]")
+    cache_size := flag.Int("cache",100000,"most sequences to keep in the fitness cache, 0 to turn caching off")
+    cache_file := flag.String("cache_file","","file to load the fitness cache from and save it to, so later runs with the same settings reuse scores")
+    workers := flag.Int("workers",runtime.GOMAXPROCS(0),"number of goroutines scoring fitness in parallel")
 
     //Simulation params
     mutation_rate := flag.Float64("mutation",0.005,"mutation rate for sequences, in [0,1]")
     indel_rate := flag.Float64("indel",0.1,"probability for mutation being an indel, in [0,1]")
+    mutation_schedule := flag.String("mutation-schedule","constant","how the mutation rate changes over generations, one of {"+strings.Join(MUTATION_SCHEDULES,"|")+"}")
+    mutation_final := flag.Float64("mutation_final",0.0005,"mutation rate reached at -maxIters by the linear and exponential schedules, and lowest rate of one-fifth, in (0,1]")
+    mutation_max := flag.Float64("mutation_max",0.05,"highest mutation rate of the one-fifth and diversity schedules, in [mutation,1]")
+    mutation_model := flag.String("mutation_model","uniform","how bases mutate, one of {error-prone-pcr|uniform} or a mutation model file")
     top_sequence_percent := flag.Float64("top_seqs",0.2,"percentage of sequences to use for breeding, in [0,1]")
-    model_file := flag.String("model","../dnazyme_ML_model/dnazyme_SGD_Classifier_v1.pickle","model used for DNAzyme evaluation (pickle of sklearn model")
-    // minimum_hairpin_length := flag.Int("hairpin_len",4,"minimum size for a sequence to be considered pallindromic")
+    model_file := flag.String("model","../dnazyme_ML_model/dnazyme_SGD_Classifier_v1.json","model used for DNAzyme evaluation (json exported by export_model.py, or pickle of sklearn model)")
+    model_timeout := flag.Float64("model_timeout",120,"seconds to wait for the python classifier worker to score a generation before restarting it, or for each -model-url request")
+    model_url := flag.String("model-url","","url of a classifier served over HTTP, e.g. http://127.0.0.1:8080/predict, used instead of -model")
+    model_batch := flag.Int("model-batch",256,"most sequences sent per -model-url request")
+    model_retries := flag.Int("model-retries",3,"times a failed -model-url request is retried, with exponential backoff")
+    model_concurrency := flag.Int("model-concurrency",4,"most -model-url requests in flight at once")
+    fitness_spec := flag.String("fitness","complementarity:0.4,classifier:0.6","weighted fitness terms as name:weight,... or a file with one name:weight per line, terms are {"+strings.Join(FitnessTermNames(),"|")+"}")
+    selection := flag.String("selection","truncation","how to select parents for breeding, one of {"+strings.Join(SELECTIONS,"|")+"}, nsga2 ranks members by Pareto front over the fitness terms")
+    crossover := flag.String("crossover","one-point","crossover operator, one of {"+strings.Join(CrossoverNames(),"|")+"}, homologous aligns the parents and cuts at aligned positions")
+    crossover_rate := flag.Float64("crossover_rate",1,"probability that a new member is crossed over, otherwise it is a mutated copy of one parent, in [0,1]")
+    tournament_size := flag.Int("tournament_size",3,"members competing in each tournament for -selection tournament, larger is more selection pressure")
+    selection_pressure := flag.Float64("selection_pressure",1.5,"expected number of picks of the fittest member relative to the average for -selection rank, in [1,2]")
+    niching := flag.String("niching","none","how to keep the population diverse, one of {"+strings.Join(NICHINGS,"|")+"}")
+    niche_distance := flag.String("niche_distance","hamming","distance between members for niching, one of {"+strings.Join(NICHE_DISTANCES,"|")+"}")
+    niche_radius := flag.Int("niche_radius",3,"members closer than this distance share fitness with -niching sharing")
+    islands := flag.Int("islands",1,"number of populations evolving in parallel, each of -size members")
+    migration_interval := flag.Int("migration_interval",5,"generations between migrations of members between islands")
+    migrants := flag.Int("migrants",5,"fittest members each island sends at every migration")
+    topology := flag.String("topology","ring","islands each island sends migrants to, one of {"+strings.Join(TOPOLOGIES,"|")+"}")
+    island_config := flag.String("island_config","","file with the breeding settings of each island, one line of key=value pairs per island, default the flag settings for every island")
+    template_name := flag.String("template","","genome template members must follow, one of {10-23|8-17} or a template file, default no template")
+    minimum_hairpin_length := flag.Int("hairpin_len",3,"minimum number of unpaired bases in a hairpin loop when folding sequences, at least 3")
+    na := flag.Float64("na",50,"monovalent cation (Na+) concentration in mM, for duplex thermodynamics")
+    mg := flag.Float64("mg",2,"Mg2+ concentration in mM, for duplex thermodynamics")
+    dntp := flag.Float64("dntp",0,"dNTP concentration in mM, for duplex thermodynamics")
+    oligo := flag.Float64("oligo",250,"total strand concentration in nM, for duplex melting temperature")
+    temperature := flag.Float64("temp",37,"temperature in C for duplex free energy")
+    background := flag.String("background","","background genome or transcriptome fasta to screen members against for off-target binding, needed by the offtarget fitness term")
+    offtarget_index := flag.String("offtarget_index","","on-disk k-mer index of the background, built if it does not exist, default <background>.kidx")
+    offtarget_k := flag.Int("offtarget_k",0,"seed k-mer length used when building the off-target index, in [4,13], 0 for the longest that finds every hit with -offtarget_mismatches")
+    offtarget_mismatches := flag.Int("offtarget_mismatches",1,"most mismatches between a member's binding site and an off-target hit")
+    offtarget_report := flag.String("offtarget_report","","tsv file to write the top off-target hits of every final member to, needs -background")
+    offtarget_top := flag.Int("offtarget_top",5,"number of off-target hits per member in the off-target report")
+
+    //SELEX params, used by -mode selex
+    mode := flag.String("mode","ga","simulation to run, one of {"+strings.Join(MODES,"|")+"}, selex simulates rounds of binding, PCR and subsampling instead of the genetic algorithm")
+    rounds := flag.Int("rounds",8,"rounds of selection for -mode selex")
+    pool := flag.Int("pool",100000,"molecules kept after each round of -mode selex, the initial library has pool/size copies of each member")
+    stringency := flag.Float64("stringency",1,"exponent on the affinity (fitness) in the retention probability of -mode selex, higher retains weak binders less")
+    carryover := flag.Float64("carryover",0.001,"probability a molecule is retained by nonspecific background binding in -mode selex, in [0,1]")
+    pcr_cycles := flag.Int("pcr_cycles",10,"PCR cycles amplifying the retained molecules each round of -mode selex")
+    pcr_efficiency := flag.Float64("pcr_efficiency",0.9,"fraction of molecules copied each PCR cycle at GC content 0.5, in [0,1]")
+    pcr_bias := flag.Float64("pcr_bias",0.2,"PCR efficiency lost at GC content 0 or 1, in [0,1]")
+    pcr_error := flag.Float64("pcr_error",0.0001,"PCR errors per base per copy, with the mutation model, in [0,1]")
+    selex_top := flag.Int("selex_top",100,"most abundant species per round written to the enrichment table $output_rounds.tsv")
 
     //Termination Params
     eval := flag.String("eval","","Only evaluates the fitness of sequences in fasta passed")
+    fold := flag.String("fold","","Only folds the sequences in fasta passed, writing the MFE and dot-bracket structure to a tsv")
+    stop_rule := flag.String("stop","plateau","when to stop before maxIters, conditions plateau, patience:{best|mean}:N[:EPS], target:F, evals:N and time:D joined by AND and OR, \"\" to run every generation")
     fitness_plateau_mode := flag.String("plateau","cov_mean","criteria for deciding on fitness plateau, one of {cov_mean|cov}")
     fitness_plateau_tolerance := flag.Float64("plateau_tol",0.005,"maximum CoV of previous generations of fitness when deciding on plateau")
     fitness_plateau_generations := flag.Int("plateau_gens",5,"number of generations to consider for evaluating fitness plateau")
+    checkpoint_every := flag.Int("checkpoint-every",0,"generations between checkpoints of the run that -resume continues from, 0 for none")
+    checkpoint_file := flag.String("checkpoint-file","","file the checkpoint is written to, default $output_checkpoint.gob")
+    resume := flag.String("resume","","checkpoint file to continue a run from, the run keeps the parameters it was started with")
+    history := flag.String("history","","file to write statistics of every generation to, with extension {.tsv|.jsonl}")
+    verbose := flag.Bool("verbose",false,"log fitness, diversity and mutation rate every generation instead of showing a progress bar")
     outputfile := flag.String("output","dnazymes.fna","output file name for final set of dnazymes, must have extension {.tsv|.fna}")
 
+    //Scan params, used by the scan command
+    arms := flag.String("arms","7-13","binding arm lengths to design when scanning, N or MIN-MAX")
+    arm_skew := flag.Int("arm_skew",0,"largest difference between the 2 arm lengths when scanning, 0 for equal arms")
+
+    //the scan command ./genetic_algorithm scan [flags] designs DNAzymes for every cleavage junction
+    command := ""
+    if len(os.Args) > 1 && os.Args[1] == "scan" {
+        command = os.Args[1]
+        flag.CommandLine.Parse(os.Args[2:])
+    } else {
+        flag.Parse()
+    }
+    var checkpoint *Checkpoint
+    if len(*resume) != 0 {//continue a run with the parameters it was started with
+        checkpoint = ReadCheckpoint(*resume)
+        checkpoint.RestoreParams()
+    }
     if *seed != 0 {
-        rand.Seed(*seed) //for testing
+        RANDOM_SOURCE.Seed(*seed) //for testing
     }
-    flag.Parse()
+    selex := SELEX{rounds:*rounds,
+                   pool:*pool,
+                   stringency:*stringency,
+                   carryover:*carryover,
+                   pcr_cycles:*pcr_cycles,
+                   pcr_efficiency:*pcr_efficiency,
+                   pcr_bias:*pcr_bias,
+                   pcr_error:*pcr_error,
+                   top:*selex_top,
+                  }
     CheckParams(*lower,
                 *upper,
                 *size,
                 *maxIterations,
                 *targetFastaFile,
                 *mutation_rate,
+                *mutation_schedule,
+                *mutation_final,
+                *mutation_max,
                 *top_sequence_percent,
                 *fitness_plateau_tolerance,
                 *fitness_plateau_generations,
+                *selection,
+                *crossover,
+                *crossover_rate,
+                *tournament_size,
+                *selection_pressure,
+                *niching,
+                *niche_distance,
+                *niche_radius,
+                *islands,
+                *migration_interval,
+                *migrants,
+                *topology,
+                *seed_fraction,
+                *seed_mutation,
+                *mode,
+                selex,
+                *checkpoint_every,
+                *history,
+                *workers,
                 *outputfile)
+    WORKERS = *workers
+    if *cache_size > 0 {
+        FITNESS_CACHE = NewFitnessCache(*cache_size,*cache_file)
+    }
+
+    //everything fitness terms need, the target is read when running
+    ctx := FitnessContext{model_file:*model_file,
+                          model_timeout:time.Duration(*model_timeout*float64(time.Second)),
+                          model_url:*model_url,
+                          model_batch:*model_batch,
+                          model_retries:*model_retries,
+                          model_concurrency:*model_concurrency,
+                          min_hairpin:*minimum_hairpin_length,
+                          conditions:Conditions{na:*na,
+                                                mg:*mg,
+                                                dntp:*dntp,
+                                                oligo:*oligo,
+                                                temperature:*temperature},
+                          offtarget_mismatches:*offtarget_mismatches,
+                          template:ParseTemplate(*template_name),
+                         }
+    if len(*background) != 0 {
+        shortest := SiteParts(ctx.template,*lower) //the seeds must find every site of the shortest members
+        if command == "scan" {
+            minArm, _ := ParseRange(*arms)
+            shortest = []int{minArm,minArm}
+        }
+        ctx.offtarget = OpenBackground(*background,*offtarget_index,SeedLength(shortest,*offtarget_mismatches,*offtarget_k))
+    }
+
+    //everything breeding needs, the selector is made when running
+    breeder := Breeder{selection:*selection,
+                       tournament_size:*tournament_size,
+                       selection_pressure:*selection_pressure,
+                       top_sequence_percent:*top_sequence_percent,
+                       crossover:*crossover,
+                       crossover_rate:*crossover_rate,
+                       mutation_model:ParseMutationModel(*mutation_model),
+                       mutation_rate:*mutation_rate,
+                       schedule:NewMutationSchedule(*mutation_schedule,*mutation_rate,*mutation_final,*mutation_max,*maxIterations),
+                       indel_rate:*indel_rate,
+                       template:ctx.template,
+                       niching:*niching,
+                       niche_distance:*niche_distance,
+                       niche_radius:*niche_radius,
+                      }
 
     //Run simulation
     if len(*eval) != 0 { //only evaluate fitness of input fasta
         pop := FastaToPopulation(*eval)
-        target := ReadTargetFromFasta(*targetFastaFile)
-        pop.ScoreFitness(target, *model_file)
+        ctx = PrepareContext(ctx,*targetFastaFile)
+        pop.ScoreFitness(ParseFitnessFunction(*fitness_spec,ctx))
         outfile := fmt.Sprintf("%s_fitness.fna",strings.Replace(*eval,".fna","",-1))
         pop.WriteToFasta(outfile)
         fmt.Println("Scored file written to ",outfile)
+        StopPythonWorkers()
+        os.Exit(0) //exit without simulating
+    }
+    if command == "scan" { //only design and score DNAzymes for every junction in target
+        template := ParseTemplate(*template_name)
+        if template == nil {
+            template = ParseTemplate("10-23")
+        }
+        ctx.template = template
+        minArm, maxArm := ParseRange(*arms)
+        outfile := fmt.Sprintf("%s_scan.tsv",strings.Replace(*targetFastaFile,".fna","",-1))
+        RunScan(*targetFastaFile,template,ctx,*fitness_spec,minArm,maxArm,*arm_skew,outfile)
+        fmt.Println("Scanned designs written to ",outfile)
+        StopPythonWorkers()
+        os.Exit(0) //exit without simulating
+    }
+    if len(*fold) != 0 { //only fold the sequences of input fasta
+        pop := FastaToPopulation(*fold)
+        outfile := fmt.Sprintf("%s_fold.tsv",strings.Replace(*fold,".fna","",-1))
+        pop.WriteFoldTSV(outfile,*minimum_hairpin_length)
+        fmt.Println("Folded file written to ",outfile)
         os.Exit(0) //exit without simulating
     }
-    lastGen := RunSimulation(*lower,
-                             *upper,
-                             *size,
-                             *maxIterations,
-                             *targetFastaFile,
-                             *mutation_rate,
-                             *indel_rate,
-                             *top_sequence_percent,
-                             *model_file,
-                             *fitness_plateau_mode,
-                             *fitness_plateau_tolerance,
-                             *fitness_plateau_generations)
+    migration := Migration{interval:*migration_interval,migrants:*migrants,topology:*topology}
+    seeding := Seeding{fraction:*seed_fraction,mutation:*seed_mutation}
+    composition := NewComposition(*base_freqs,*gc_range,*length_dist,*length_sd,*max_homopolymer)
+    if len(*seed_pop) != 0 {
+        seeding.seeds = ReadSeeds(*seed_pop,breeder.template)
+    }
+    stopper := ParseStopRule(*stop_rule,*fitness_plateau_mode,*fitness_plateau_tolerance,*fitness_plateau_generations)
+    outputBase := strings.TrimSuffix(*outputfile,filepath.Ext(*outputfile))
+    info := RunInfo{Mode:*mode,StopRule:*stop_rule}
+    start := time.Now()
+    checkpointing := Checkpointing{every:*checkpoint_every,
+                                   filename:*checkpoint_file,
+                                   params:FlagValues(),
+                                   resume:checkpoint,
+                                  }
+    if len(checkpointing.filename) == 0 {
+        checkpointing.filename = outputBase+"_checkpoint.gob"
+    }
+    if checkpoint != nil {//count the time before the checkpoint
+        start = start.Add(-checkpoint.Elapsed)
+    }
+    var lastGen Population
+    if *mode == "selex" {
+        info.StopRule = ""
+        lastGen, info.Generations, info.StopReason = RunSELEX(*lower,
+                                                              *upper,
+                                                              *size,
+                                                              *targetFastaFile,
+                                                              ctx,
+                                                              *fitness_spec,
+                                                              breeder,
+                                                              seeding,
+                                                              composition,
+                                                              selex,
+                                                              outputBase+"_rounds.tsv")
+    } else {
+        var finalIslands []*Island
+        lastGen, finalIslands, info.StopReason = RunSimulation(*lower,
+                                                               *upper,
+                                                               *size,
+                                                               *maxIterations,
+                                                               *targetFastaFile,
+                                                               ctx,
+                                                               *fitness_spec,
+                                                               IslandBreeders(breeder,*island_config,*islands),
+                                                               migration,
+                                                               seeding,
+                                                               composition,
+                                                               stopper,
+                                                               checkpointing,
+                                                               *history,
+                                                               *verbose)
+        for _,island := range finalIslands {
+            info.Generations = Max(info.Generations,island.gen)
+        }
+        if len(finalIslands) > 1 {
+            for _,island := range finalIslands {
+                info.Islands = append(info.Islands,island.StopReason())
+            }
+            SummarizeIslands(finalIslands,outputBase+"_islands.tsv")
+        }
+    }
+    info.Evaluations = FITNESS_EVALUATIONS
+    info.Interrupted = Interrupted()
+    info.Seconds = time.Since(start).Seconds()
     fmt.Println("Final Generation Fitness Summary")
+    fmt.Println("Stopped: ",info.StopReason)
     lastGen.Summarize()
-    lastGen.WriteResults(*outputfile)
+    FITNESS_CACHE.Summarize()
+    FITNESS_CACHE.Save()
+    resultsfile := *outputfile
+    if info.Interrupted {//keep the results of an unfinished run apart from finished ones
+        resultsfile = outputBase+"_interrupted"+filepath.Ext(*outputfile)
+        fmt.Println("Run interrupted, writing the current population to ",resultsfile)
+    }
+    lastGen.WriteResults(resultsfile)
+    info.Write(outputBase+"_run.json")
+    fmt.Println("Run metadata written to ",outputBase+"_run.json")
+    StopPythonWorkers()
+    if len(*offtarget_report) != 0 {
+        if ctx.offtarget == nil {
+            panic("-offtarget_report needs a background fasta, see -background")
+        }
+        lastGen.WriteOffTargetReport(*offtarget_report,ctx.offtarget,PrepareContext(ctx,*targetFastaFile),*offtarget_top)
+        fmt.Println("Off-target hits written to ",*offtarget_report)
+    }
 }
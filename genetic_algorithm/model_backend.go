@@ -0,0 +1,165 @@
+package main
+
+import(
+    "fmt"
+    "math"
+    "sync"
+    "time"
+    "bytes"
+    "strings"
+    "net/http"
+    "encoding/json"
+)
+
+//Model backends
+//the classifier fitness term asks a ModelBackend for the probability that each member is a DNAzyme
+//  json models are scored natively in Go (classifier.go)
+//  pickled models are scored by a persistent python worker (python_worker.go)
+//  -model-url sends sequences to a classifier served over HTTP, e.g. a local sidecar
+
+var HTTP_BACKOFF = 500*time.Millisecond //wait before the first retry, doubled after each one, shortened by tests
+
+// ModelBackend scores members with a DNAzyme classifier
+type ModelBackend interface {
+    Predict(pop Population) []float64 //probability of being a DNAzyme, in population order
+    ChunkSize() int //members per call, 0 to score the whole population in one call
+}
+
+// NewModelBackend() the backend for the model settings
+// input: fitness context with the model file or url and its settings
+// output: backend, python workers are started here so they load with the simulation
+func NewModelBackend(ctx FitnessContext) ModelBackend {
+    switch {
+        case ctx.model_url != "":
+            return NewHTTPBackend(ctx.model_url,ctx.model_batch,ctx.model_retries,ctx.model_concurrency,ctx.model_timeout)
+        case strings.HasSuffix(ctx.model_file,".json"):
+            return NativeBackend{model:LoadClassifier(ctx.model_file)}
+        default:
+            return GetPythonWorker(ctx.model_file,ctx.model_timeout)
+    }
+}
+
+// NativeBackend scores with a json model in Go
+type NativeBackend struct {
+    model *KmerClassifier
+}
+func (b NativeBackend) Predict(pop Population) []float64 { return b.model.PredictPopulation(pop) }
+func (b NativeBackend) ChunkSize() int { return 256 }
+
+// ChunkSize() the python worker scores one batch at a time so it gets the whole population
+func (w *PythonWorker) ChunkSize() int { return 0 }
+
+// HTTPRequest is the body posted to the model url
+type HTTPRequest struct {
+    Seqs []string `json:"seqs"`
+}
+// HTTPResponse is the body the model url answers with
+type HTTPResponse struct {
+    Predictions []float64 `json:"predictions"`
+}
+// HTTPBackend posts batches of sequences to a classifier served over HTTP
+type HTTPBackend struct {
+    url string
+    batch int //most sequences per request
+    retries int //times a failed request is retried
+    slots chan bool //limits requests in flight
+    client *http.Client
+}
+
+// NewHTTPBackend() a backend for a model served at url
+// input: url, batch size, retries, most concurrent requests and the request timeout
+// output: pointer to the backend
+func NewHTTPBackend(url string, batch int, retries int, concurrency int, timeout time.Duration) *HTTPBackend {
+    switch false {
+        case strings.HasPrefix(url,"http://") || strings.HasPrefix(url,"https://"):
+            panic("model url must start with http:// or https://")
+        case batch >= 1:
+            panic("model batch size must be >= 1")
+        case retries >= 0:
+            panic("model retries must be >= 0")
+        case concurrency >= 1:
+            panic("model concurrency must be >= 1")
+    }
+    return &HTTPBackend{url:url,
+                        batch:batch,
+                        retries:retries,
+                        slots:make(chan bool,concurrency),
+                        client:&http.Client{Timeout:timeout},
+                       }
+}
+func (b *HTTPBackend) ChunkSize() int { return 0 }
+// Predict() scores a population in batches, sending up to the concurrency limit at once
+func (b *HTTPBackend) Predict(pop Population) []float64 {
+    predictions := make([]float64,len(pop))
+    var wg sync.WaitGroup
+    for start := 0; start < len(pop); start += b.batch {
+        end := Min(start+b.batch,len(pop))
+        seqs := make([]string,end-start)
+        for i,member := range pop[start:end] {
+            seqs[i] = member.seq
+        }
+        wg.Add(1)
+        b.slots <- true
+        go func(start int) {
+            defer wg.Done()
+            defer func() { <-b.slots }()
+            copy(predictions[start:],b.PostBatch(seqs))
+        }(start)
+    }
+    wg.Wait()
+    return predictions
+}
+// PostBatch() scores one batch, retrying with exponential backoff on
+// connection errors, timeouts and 429/5xx responses
+// input: sequences of the batch
+// output: validated predictions, panics if every try fails or the response is invalid
+func (b *HTTPBackend) PostBatch(seqs []string) []float64 {
+    body, err := json.Marshal(HTTPRequest{Seqs:seqs})
+    if err != nil { panic(err) }
+    backoff := HTTP_BACKOFF
+    for try := 0; ; try++ {
+        predictions, retry, err := b.Post(body)
+        if err == nil {
+            if err := ValidatePredictions(predictions,len(seqs)); err != nil {
+                panic(fmt.Sprintf("model url %s: %v",b.url,err))
+            }
+            return predictions
+        }
+        if !retry || try == b.retries {
+            panic(fmt.Sprintf("model url %s: %v",b.url,err))
+        }
+        fmt.Println("Model request failed, retrying in",backoff,":",err)
+        time.Sleep(backoff)
+        backoff *= 2
+    }
+}
+// Post() one request to the model url
+// output: predictions, whether a failure is worth retrying, error if it failed
+func (b *HTTPBackend) Post(body []byte) ([]float64, bool, error) {
+    response, err := b.client.Post(b.url,"application/json",bytes.NewReader(body))
+    if err != nil {
+        return nil, true, err
+    }
+    defer response.Body.Close()
+    if response.StatusCode != http.StatusOK {
+        retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+        return nil, retry, fmt.Errorf("status %s",response.Status)
+    }
+    var decoded HTTPResponse
+    if err := json.NewDecoder(response.Body).Decode(&decoded); err != nil {
+        return nil, false, fmt.Errorf("invalid response: %v",err)
+    }
+    return decoded.Predictions, false, nil
+}
+// ValidatePredictions() checks there is one probability in [0,1] per sequence
+func ValidatePredictions(predictions []float64, n int) error {
+    if len(predictions) != n {
+        return fmt.Errorf("%d predictions for %d sequences",len(predictions),n)
+    }
+    for i,p := range predictions {
+        if math.IsNaN(p) || !Between(p,0,1) {
+            return fmt.Errorf("prediction %d is %v, not a probability",i,p)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import(
+    "sync"
+    "time"
+    "strings"
+    "testing"
+    "net/http"
+    "encoding/json"
+    "net/http/httptest"
+)
+
+// StubModel is a model server for tests, as stub_server/ it predicts the GC content of each sequence
+type StubModel struct {
+    fail int //answer the first fail requests with failStatus
+    failStatus int
+    short bool //drop the last prediction of every response
+    delay time.Duration
+    requests int
+    batches []int //number of sequences in each request
+    inFlight int
+    maxInFlight int
+    lock sync.Mutex
+}
+
+// NewStubServer() a test server answering with the stub model, closed when the test ends
+func NewStubServer(t *testing.T, stub *StubModel) *httptest.Server {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var request HTTPRequest
+        if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+            http.Error(w,err.Error(),http.StatusBadRequest)
+            return
+        }
+        stub.lock.Lock()
+        stub.requests++
+        n := stub.requests
+        stub.batches = append(stub.batches,len(request.Seqs))
+        stub.inFlight++
+        stub.maxInFlight = Max(stub.maxInFlight,stub.inFlight)
+        stub.lock.Unlock()
+        defer func() {
+            stub.lock.Lock()
+            stub.inFlight--
+            stub.lock.Unlock()
+        }()
+        time.Sleep(stub.delay)
+        if n <= stub.fail {
+            http.Error(w,"injected failure",stub.failStatus)
+            return
+        }
+        var response HTTPResponse
+        for _,seq := range request.Seqs {
+            gc := float64(strings.Count(seq,"G")+strings.Count(seq,"C"))/float64(len(seq))
+            response.Predictions = append(response.Predictions,gc)
+        }
+        if stub.short {
+            response.Predictions = response.Predictions[:len(response.Predictions)-1]
+        }
+        json.NewEncoder(w).Encode(response)
+    }))
+    t.Cleanup(server.Close)
+    return server
+}
+
+// Requests() number of requests the stub has had
+func (stub *StubModel) Requests() int {
+    stub.lock.Lock()
+    defer stub.lock.Unlock()
+    return stub.requests
+}
+
+// ExpectPanic() fails the test unless f panics with a message containing want
+func ExpectPanic(t *testing.T, want string, f func()) {
+    t.Helper()
+    defer func() {
+        r := recover()
+        if r == nil {
+            t.Fatalf("no panic, want %q",want)
+        }
+        if message, _ := r.(string); !strings.Contains(message,want) {
+            t.Fatalf("panic %v, want %q",r,want)
+        }
+    }()
+    f()
+}
+
+// ShortBackoff() shortens the retry backoff for the length of the test
+func ShortBackoff(t *testing.T) {
+    backoff := HTTP_BACKOFF
+    HTTP_BACKOFF = time.Millisecond
+    t.Cleanup(func() { HTTP_BACKOFF = backoff })
+}
+
+// TestHTTPBackendBatches checks populations are split into batches, predictions come back in order
+// and no more than the concurrency limit of requests are in flight
+func TestHTTPBackendBatches(t *testing.T) {
+    stub := &StubModel{delay:20*time.Millisecond}
+    server := NewStubServer(t,stub)
+    backend := NewHTTPBackend(server.URL,3,0,2,time.Second)
+    pop := Population{{seq:"GGGG"},{seq:"AAAA"},{seq:"GCAT"},{seq:"GAAA"},{seq:"CCCA"},
+                      {seq:"TTTT"},{seq:"ACGT"},{seq:"GGGC"},{seq:"ATAT"},{seq:"CATG"}}
+    predictions := backend.Predict(pop)
+    want := []float64{1,0,0.5,0.25,0.75,0,0.5,1,0,0.5}
+    for i := range want {
+        if predictions[i] != want[i] {
+            t.Errorf("prediction %d of %s is %v, want %v",i,pop[i].seq,predictions[i],want[i])
+        }
+    }
+    if stub.Requests() != 4 {
+        t.Errorf("%d requests for 10 sequences in batches of 3, want 4",stub.Requests())
+    }
+    for _,n := range stub.batches {
+        if n > 3 {
+            t.Errorf("batch of %d sequences, want at most 3",n)
+        }
+    }
+    if stub.maxInFlight > 2 {
+        t.Errorf("%d requests in flight, want at most 2",stub.maxInFlight)
+    }
+}
+
+// TestHTTPBackendRetries checks 5xx and 429 answers are retried up to the retry limit
+func TestHTTPBackendRetries(t *testing.T) {
+    ShortBackoff(t)
+    for _,status := range []int{http.StatusServiceUnavailable,http.StatusTooManyRequests} {
+        stub := &StubModel{fail:2,failStatus:status}
+        backend := NewHTTPBackend(NewStubServer(t,stub).URL,8,2,1,time.Second)
+        predictions := backend.PostBatch([]string{"GG","AT"})
+        if len(predictions) != 2 || predictions[0] != 1 || predictions[1] != 0 {
+            t.Errorf("predictions %v after %d failures, want [1 0]",predictions,status)
+        }
+        if stub.Requests() != 3 {
+            t.Errorf("%d requests with 2 %d failures, want 3",stub.Requests(),status)
+        }
+    }
+    stub := &StubModel{fail:3,failStatus:http.StatusServiceUnavailable}
+    backend := NewHTTPBackend(NewStubServer(t,stub).URL,8,2,1,time.Second)
+    ExpectPanic(t,"503",func() { backend.PostBatch([]string{"GG"}) })
+    if stub.Requests() != 3 {
+        t.Errorf("%d requests with 2 retries, want 3",stub.Requests())
+    }
+}
+
+// TestHTTPBackendNoRetry checks other failed answers stop the run at once
+func TestHTTPBackendNoRetry(t *testing.T) {
+    ShortBackoff(t)
+    stub := &StubModel{fail:1,failStatus:http.StatusBadRequest}
+    backend := NewHTTPBackend(NewStubServer(t,stub).URL,8,3,1,time.Second)
+    ExpectPanic(t,"400",func() { backend.PostBatch([]string{"GG"}) })
+    if stub.Requests() != 1 {
+        t.Errorf("%d requests after a 400, want 1",stub.Requests())
+    }
+}
+
+// TestHTTPBackendTimeout checks requests slower than the timeout are retried then fail
+func TestHTTPBackendTimeout(t *testing.T) {
+    ShortBackoff(t)
+    stub := &StubModel{delay:200*time.Millisecond}
+    backend := NewHTTPBackend(NewStubServer(t,stub).URL,8,1,1,20*time.Millisecond)
+    ExpectPanic(t,"model url",func() { backend.PostBatch([]string{"GG"}) })
+    if stub.Requests() != 2 {
+        t.Errorf("%d requests with 1 retry, want 2",stub.Requests())
+    }
+}
+
+// TestHTTPBackendWrongLength checks a response with the wrong number of predictions is rejected
+func TestHTTPBackendWrongLength(t *testing.T) {
+    stub := &StubModel{short:true}
+    backend := NewHTTPBackend(NewStubServer(t,stub).URL,8,3,1,time.Second)
+    ExpectPanic(t,"1 predictions for 2 sequences",func() { backend.PostBatch([]string{"GG","AT"}) })
+    if stub.Requests() != 1 {
+        t.Errorf("%d requests for an invalid response, want 1",stub.Requests())
+    }
+}
+
+// TestValidatePredictions checks predictions must be probabilities
+func TestValidatePredictions(t *testing.T) {
+    if err := ValidatePredictions([]float64{0,0.5,1},3); err != nil {
+        t.Error(err)
+    }
+    for _,predictions := range [][]float64{{0.5,1.5},{-0.1,0.5},{0.5}} {
+        if ValidatePredictions(predictions,2) == nil {
+            t.Errorf("predictions %v accepted for 2 sequences",predictions)
+        }
+    }
+}
@@ -0,0 +1,235 @@
+package main
+
+import(
+    "os"
+    "fmt"
+    "bufio"
+    "strings"
+    "strconv"
+    "math/rand"
+)
+
+//Mutation models
+//a base mutates with probability mutation_rate times its relative mutability,
+//a mutation is an indel with probability indel_rate and a substitution otherwise
+//  substitutions pick the new base from a per base substitution matrix
+//  indels are insertions or deletions with geometric lengths
+//  homopolymer runs also slip, gaining or losing one base, as polymerases do
+
+const MAX_INDEL = 50 //longest indel drawn from the geometric distribution
+
+// MutationModel describes how bases are substituted, inserted and deleted
+type MutationModel struct {
+    name string
+    substitution [4][4]float64 //relative rate from base (row) to base (column), order ACGT
+    indel_extend float64 //probability an indel extends by another base, lengths are geometric with mean 1/(1-p)
+    insertion_fraction float64 //fraction of indels that are insertions
+    slippage float64 //slippage rate per base of a homopolymer run, relative to mutation_rate
+    slippage_min int //shortest homopolymer run that slips
+}
+
+// MUTATION_PRESETS are the mutation models available by name with -mutation_model
+//  uniform          every substitution equally likely and single base indels (the original model)
+//  error-prone-pcr  approximate Taq/Mn2+ spectrum (Cadwell & Joyce 1994), A/T mutate more,
+//                   mostly A->G,T->C transitions and A<->T transversions, with slippage in runs of 4+
+var MUTATION_PRESETS = map[string]MutationModel{
+    "uniform": MutationModel{name:"uniform",
+                             substitution:[4][4]float64{
+                                 {0,1,1,1},
+                                 {1,0,1,1},
+                                 {1,1,0,1},
+                                 {1,1,1,0},
+                             },
+                             insertion_fraction:0.5,
+                             slippage_min:4},
+    "error-prone-pcr": MutationModel{name:"error-prone-pcr",
+                                     substitution:[4][4]float64{//to A     C     G     T
+                                         {0,0.05,0.41,0.36},      //A
+                                         {0.05,0,0.01,0.14},      //C
+                                         {0.14,0.01,0,0.05},      //G
+                                         {0.36,0.41,0.05,0},      //T
+                                     },
+                                     indel_extend:0.2,
+                                     insertion_fraction:0.5,
+                                     slippage:1,
+                                     slippage_min:4},
+}
+
+// TransitionMatrix() substitution matrix with a transition/transversion rate ratio
+// transitions (A<->G, C<->T) have rate kappa and each transversion rate 1,
+// so kappa 1 is uniform and the fraction of transitions is kappa/(kappa+2)
+func TransitionMatrix(kappa float64) [4][4]float64 {
+    var matrix [4][4]float64
+    for i := range matrix {
+        for j := range matrix[i] {
+            switch {
+                case i == j:
+                    matrix[i][j] = 0
+                case i^j == 2://A(0)<->G(2), C(1)<->T(3)
+                    matrix[i][j] = kappa
+                default:
+                    matrix[i][j] = 1
+            }
+        }
+    }
+    return matrix
+}
+
+// ParseMutationModel() gets a preset mutation model by name or reads one from a file
+// input: preset name or file name
+// output: pointer to the MutationModel
+func ParseMutationModel(name string) *MutationModel {
+    if model, ok := MUTATION_PRESETS[name]; ok {
+        return &model
+    }
+    return ReadMutationModel(name)
+}
+// ReadMutationModel() reads a mutation model file, one setting per line as
+//   preset NAME               (start from a preset, default uniform)
+//   tstv KAPPA                (substitution matrix from a ts/tv rate ratio)
+//   matrix BASE rA rC rG rT   (relative substitution rates from BASE to each base)
+//   indel_extend P            (indel lengths are geometric, mean 1/(1-P))
+//   insertion_fraction F      (fraction of indels that are insertions)
+//   slippage S                (homopolymer slippage rate per run base, relative to the mutation rate)
+//   slippage_min N            (shortest homopolymer run that slips)
+// blank lines and anything after # are ignored, later lines override earlier ones
+// input: mutation model file name
+// output: pointer to the MutationModel, panics if the file is invalid
+func ReadMutationModel(filename string) *MutationModel {
+    modelFile, err := os.Open(filename)
+    if err != nil { panic(err) }
+    defer modelFile.Close()
+    model := MUTATION_PRESETS["uniform"]
+    scanner := bufio.NewScanner(modelFile)
+    for scanner.Scan() {
+        fields := strings.Fields(strings.Split(scanner.Text(),"#")[0])
+        if len(fields) == 0 {
+            continue
+        }
+        var values []float64
+        for _,field := range fields[1:] {
+            if value, err := strconv.ParseFloat(field,64); err == nil {
+                values = append(values,value)
+            }
+        }
+        invalid := fmt.Sprintf("Invalid mutation model line %q",scanner.Text())
+        switch {
+            case fields[0] == "preset" && len(fields) == 2:
+                preset, ok := MUTATION_PRESETS[fields[1]]
+                if !ok {
+                    panic(fmt.Sprintf("Unknown mutation model preset %q",fields[1]))
+                }
+                model = preset
+            case fields[0] == "tstv" && len(values) == 1 && values[0] > 0:
+                model.substitution = TransitionMatrix(values[0])
+            case fields[0] == "matrix" && len(fields) == 6 && len(values) == 4:
+                row, ok := BASE_CODES[strings.ToUpper(fields[1])[0]]
+                if !ok || len(fields[1]) != 1 {
+                    panic(invalid)
+                }
+                for j,value := range values {
+                    if value < 0 {
+                        panic(invalid)
+                    }
+                    if uint64(j) != row {//a base never substitutes to itself
+                        model.substitution[row][j] = value
+                    }
+                }
+            case fields[0] == "indel_extend" && len(values) == 1 && Between(values[0],0,0.99):
+                model.indel_extend = values[0]
+            case fields[0] == "insertion_fraction" && len(values) == 1 && Between(values[0],0,1):
+                model.insertion_fraction = values[0]
+            case fields[0] == "slippage" && len(values) == 1 && values[0] >= 0:
+                model.slippage = values[0]
+            case fields[0] == "slippage_min" && len(values) == 1 && values[0] >= 2:
+                model.slippage_min = int(values[0])
+            default:
+                panic(invalid)
+        }
+    }
+    if err := scanner.Err(); err != nil { panic(err) }
+    model.name = filename
+    return &model
+}
+
+// Mutability() relative mutation rate of each base, averaging 1
+// bases with larger substitution matrix rows mutate more often
+func (m *MutationModel) Mutability() [4]float64 {
+    var rows [4]float64
+    total := 0.0
+    for i := range m.substitution {
+        for _,rate := range m.substitution[i] {
+            rows[i] += rate
+        }
+        total += rows[i]
+    }
+    for i := range rows {
+        if total > 0 {
+            rows[i] *= 4/total
+        }
+    }
+    return rows
+}
+// Substitute() picks the base a base mutates to from its substitution matrix row
+func (m *MutationModel) Substitute(rng *rand.Rand, base byte) byte {
+    row := m.substitution[BASE_CODES[base]]
+    r := rng.Float64()*(row[0]+row[1]+row[2]+row[3])
+    for j,rate := range row {
+        if r < rate {
+            return byte(DNA_ALPHABET[j])
+        }
+        r -= rate
+    }
+    return PickDifferentRandomBase(rng,rune(base))[0] //only reached through rounding
+}
+// IndelLength() length of an indel, geometric with extension probability indel_extend
+func (m *MutationModel) IndelLength(rng *rand.Rand) int {
+    length := 1
+    for length < MAX_INDEL && rng.Float64() < m.indel_extend {
+        length++
+    }
+    return length
+}
+// Mutate() mutates a DNA string following the model
+// input: random numbers to draw from, DNA string, mutation rate and probability that a mutation is an indel
+// output: mutated DNA string
+func (m *MutationModel) Mutate(rng *rand.Rand, seq string, mutation_rate,indel_rate float64) string {
+    mutability := m.Mutability()
+    mutated := make([]byte,0,len(seq)+1)
+    for i := 0; i < len(seq); i++ {
+        base := seq[i]
+        if m.slippage > 0 && indel_rate > 0 && (i == 0 || seq[i-1] != base) {//start of a run, slips only if indels are allowed
+            run := 1
+            for i+run < len(seq) && seq[i+run] == base {
+                run++
+            }
+            if run >= m.slippage_min && rng.Float64() < mutation_rate*m.slippage*float64(run) {
+                if rng.Float64() < m.insertion_fraction {//the run gains a base
+                    mutated = append(mutated,base)
+                } else {//or loses one
+                    i++
+                    if i == len(seq) {
+                        break
+                    }
+                    base = seq[i]
+                }
+            }
+        }
+        code, ok := BASE_CODES[base]
+        if !ok || rng.Float64() >= mutation_rate*mutability[code] {//dont mutate
+            mutated = append(mutated,base)
+            continue
+        }
+        if rng.Float64() >= indel_rate {//regular mutation
+            mutated = append(mutated,m.Substitute(rng,base))
+        } else if rng.Float64() < m.insertion_fraction {// insert new bases after this one
+            mutated = append(mutated,base)
+            for length := m.IndelLength(rng); length > 0; length-- {
+                mutated = append(mutated,PickRandomBase(rng)[0])
+            }
+        } else {//delete this base and the next length-1 (add nothing)
+            i += m.IndelLength(rng)-1
+        }
+    }
+    return string(mutated)
+}
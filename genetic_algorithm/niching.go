@@ -0,0 +1,207 @@
+package main
+
+import(
+    "math/rand"
+)
+
+//Niching
+//keeps the population diverse so it does not collapse to copies of one sequence, with -niching
+//  none           no niching (default)
+//  sharing        parents and elites are selected on shared fitness, fitness divided by the
+//                 niche count, the number of members within niche_radius of a member (Goldberg & Richardson 1987)
+//  crowding       deterministic crowding (Mahfoud 1995), random parents are paired and each child
+//                 replaces the more similar parent only if it is at least as fit
+//  no-duplicates  elites are unique and clones of a member already in the next generation
+//                 are replaced by fresh offspring
+//distances between members are hamming or edit distances, set with -niche_distance
+
+var NICHINGS = []string{"none","sharing","crowding","no-duplicates"}
+var NICHE_DISTANCES = []string{"hamming","edit"}
+const MAX_CLONE_RETRIES = 20 //new offspring bred to replace a clone before keeping it
+
+// Distance() distance between 2 DNA strings with the breeder's niche distance
+// distances above limit may be returned as limit+1
+func (breeder Breeder) Distance(s,t string, limit int) int {
+    switch breeder.niche_distance {
+        case "hamming":
+            return HammingDistance(s,t)
+        case "edit":
+            return EditDistance(s,t,limit)
+        default:
+            panic("Invalid niche distance, must be one of {hamming|edit}")
+    }
+}
+// HammingDistance() mismatches between s and t plus the difference in their lengths
+func HammingDistance(s,t string) int {
+    distance := len(s)+len(t)-2*Min(len(s),len(t))
+    for i := 0; i < Min(len(s),len(t)); i++ {
+        if s[i] != t[i] {
+            distance++
+        }
+    }
+    return distance
+}
+// EditDistance() Levenshtein distance between s and t, only computed in a band of width limit
+// output: the distance, or limit+1 if it is larger than limit
+func EditDistance(s,t string, limit int) int {
+    if len(s)-len(t) > limit || len(t)-len(s) > limit {
+        return limit+1
+    }
+    far := limit+1 //stands in for every distance above limit
+    previous := make([]int,len(t)+1)
+    current := make([]int,len(t)+1)
+    for j := range previous {
+        previous[j] = Min(j,far)
+    }
+    for i := 1; i <= len(s); i++ {
+        for j := range current {
+            current[j] = far
+        }
+        current[0] = Min(i,far)
+        rowMin := current[0]
+        for j := Max(1,i-limit); j <= Min(len(t),i+limit); j++ {
+            cost := 1
+            if s[i-1] == t[j-1] {
+                cost = 0
+            }
+            current[j] = Min(Min(previous[j]+1,current[j-1]+1),Min(previous[j-1]+cost,far))
+            rowMin = Min(rowMin,current[j])
+        }
+        if rowMin >= far {//every path is already too long
+            return far
+        }
+        previous, current = current, previous
+    }
+    return previous[len(t)]
+}
+
+// NicheCounter scores each member with its niche count among the unique sequences of a population
+// a FitnessTerm so niche counts are computed by the worker pool
+type NicheCounter struct {
+    breeder Breeder
+    unique Population
+    copies []int //copies of each unique sequence
+}
+func (n NicheCounter) Name() string {
+    return "niche_count"
+}
+// Score() niche count of every member, sum over members within niche_radius of 1-distance/niche_radius
+func (n NicheCounter) Score(pop Population) []float64 {
+    counts := make([]float64,len(pop))
+    radius := n.breeder.niche_radius
+    for i,member := range pop {
+        for j,other := range n.unique {
+            if d := n.breeder.Distance(member.seq,other.seq,radius); d < radius {
+                counts[i] += float64(n.copies[j])*(1-float64(d)/float64(radius))
+            }
+        }
+    }
+    return counts
+}
+// SharedFitness() copy of the population with every fitness divided by its niche count
+// negative fitness is multiplied by the niche count instead, so crowding always lowers fitness
+func (pop Population) SharedFitness(breeder Breeder) Population {
+    unique := pop.Collapse()
+    copies := make([]int,len(unique))
+    for i,member := range unique {
+        copies[i] = member.copies
+    }
+    niches := ScoreParallel(NicheCounter{breeder:breeder,unique:unique,copies:copies},pop)
+    shared := make(Population,len(pop))
+    copy(shared,pop)
+    for i := range shared {
+        if shared[i].fitness >= 0 {
+            shared[i].fitness /= niches[i]
+        } else {
+            shared[i].fitness *= niches[i]
+        }
+    }
+    return shared
+}
+// RawFitness() replaces the shared fitness of selected members with the fitness of their sequence
+func (pop Population) RawFitness(fitnesses map[string]float64) Population {
+    raw := make(Population,len(pop))
+    for i,member := range pop {
+        member.fitness = fitnesses[member.seq]
+        raw[i] = member
+    }
+    return raw
+}
+// FitnessBySeq() fitness of every sequence in the population
+func (pop Population) FitnessBySeq() map[string]float64 {
+    fitnesses := make(map[string]float64,len(pop))
+    for _,member := range pop {
+        fitnesses[member.seq] = member.fitness
+    }
+    return fitnesses
+}
+
+// Collapse() one member per distinct sequence, in order of first appearance
+// members that already stand for several copies (e.g. SELEX species) count as that many
+// output: the unique members, each with copies set to how many times its sequence appears
+func (pop Population) Collapse() Population {
+    index := make(map[string]int,len(pop))
+    var unique Population
+    for _,member := range pop {
+        copies := Max(1,member.copies)
+        if i, seen := index[member.seq]; seen {
+            unique[i].copies += copies
+            continue
+        }
+        index[member.seq] = len(unique)
+        member.copies = copies
+        unique = append(unique,member)
+    }
+    return unique
+}
+
+// CrowdingGeneration() next generation by deterministic crowding
+// members are paired at random, each pair breeds 2 children (one with each parent first),
+// each child is matched to the closer parent and the fitter of the two survives, ties to the child
+// input: the island's random numbers, a population of sequences, the fitness function and the breeding settings
+// output: new population of Sequences, the same size
+func CrowdingGeneration(rng *rand.Rand, generation Population, fitness FitnessFunction, breeder Breeder) Population {
+    order := rng.Perm(len(generation))
+    children := make(Population,0,len(generation))
+    for k := 0; k+1 < len(order); k += 2 {
+        p1, p2 := generation[order[k]], generation[order[k+1]]
+        children = append(children,BreedSequence(rng,p1,p2,k,breeder),BreedSequence(rng,p2,p1,k+1,breeder))
+    }
+    children.ScoreFitness(fitness)
+    nextGeneration := make(Population,len(generation))
+    for k := 0; k+1 < len(order); k += 2 {
+        p1, p2 := generation[order[k]], generation[order[k+1]]
+        c1, c2 := children[k], children[k+1]
+        far := len(p1.seq)+len(p2.seq)+len(c1.seq)+len(c2.seq) //no limit
+        if breeder.Distance(p1.seq,c1.seq,far)+breeder.Distance(p2.seq,c2.seq,far) >
+           breeder.Distance(p1.seq,c2.seq,far)+breeder.Distance(p2.seq,c1.seq,far) {
+            c1, c2 = c2, c1
+        }
+        nextGeneration[k] = Compete(p1,c1,k)
+        nextGeneration[k+1] = Compete(p2,c2,k+1)
+    }
+    if len(order)%2 == 1 {//the unpaired member survives
+        last := len(order)-1
+        nextGeneration[last] = generation[order[last]].Survivor(last)
+    }
+    return nextGeneration
+}
+// Compete() the child if it is at least as fit as the parent, otherwise the parent
+func Compete(parent,child Member, label int) Member {
+    if child.fitness >= parent.fitness {
+        child.label = label
+        return child
+    }
+    return parent.Survivor(label)
+}
+// Survivor() copy of a member passed unchanged to the next generation
+func (m Member) Survivor(label int) Member {
+    return Member{label:label,
+                  fitness:m.fitness,
+                  seq:m.seq,
+                  header:m.header,
+                  scores:m.scores,
+                  annotations:m.annotations,
+                  segments:m.segments,
+                 }
+}
@@ -0,0 +1,527 @@
+package main
+
+import(
+    "os"
+    "io"
+    "fmt"
+    "sort"
+    "math"
+    "bufio"
+    "strings"
+    "encoding/binary"
+)
+
+//Off-target screening
+//a background genome or transcriptome fasta is indexed once into an on-disk k-mer index
+//every member is then searched against it (seed with exact k-mers, then count
+//mismatches over the whole ungapped window) to find other sequences it would bind
+//seeding finds every window with at most m mismatches only if the site holds m+1 non-overlapping
+//k-mers, so k is chosen (or checked) against the shortest binding site, see SeedLength()
+//index file layout, all integers little endian
+//  header      magic, k, number of bases, number of sequences, section offsets,
+//              size and modification time of the background it was built from, number of masked runs
+//  offsets     (4^k+1) uint64, start of the position list of each k-mer
+//  sequence    background packed 2 bits per base (N is stored as A and masked)
+//  positions   uint32 position of every k-mer, grouped by k-mer
+//  sequences   name, start and length of every background sequence
+//  masked      start and length of every run of N (or other non ACGT) bases, these match no base
+
+const INDEX_MAGIC = "SXZKIDX2"
+const INDEX_MAGIC_PREFIX = "SXZKIDX" //older versions of the index are rebuilt
+const INDEX_HEADER_SIZE = 80
+const INDEX_MAX_OCCURRENCES = 10000 //k-mers more frequent than this are repeats, not used as seeds
+const INDEX_PASS_POSITIONS = 1 << 28 //positions held in memory per indexing pass (1GB)
+const INDEX_MAX_K = 13 //4^k counts and offsets are held in memory while indexing, 800MB for k=13
+var BASE_CODES = map[byte]uint64{'A':0,'C':1,'G':2,'T':3}
+
+// BackgroundSeq is one sequence of the indexed background
+type BackgroundSeq struct {
+    name string
+    start uint64 //position of the first base in the concatenated background
+    length uint64
+}
+// OffTargetIndex is an open on-disk k-mer index of a background fasta
+type OffTargetIndex struct {
+    file *os.File
+    path string
+    k int
+    total uint64 //number of bases in the background
+    background_size int64 //size and modification time (unix ns) of the background fasta
+    background_time int64
+    offsetsAt int64 //file offsets of each section
+    sequenceAt int64
+    positionsAt int64
+    seqs []BackgroundSeq
+    masked [][2]uint64 //start and length of runs of N, in order
+}
+// OffTargetHit is one place in the background a member could bind
+type OffTargetHit struct {
+    name string //background sequence
+    position uint64 //0 based position in the background sequence
+    strand byte //+ if the background sequence binds the member, - if its complement does
+    mismatches int
+    dG float64 //best duplex of the member and the bound sequence
+}
+
+// ScanFasta() streams a (possibly multi-GB) fasta file base by base
+// input: fasta file name, called for each header and each base,
+//        a header without a name is named unnamed_N for the Nth sequence
+// output: no return, panics if the file cannot be read
+func ScanFasta(fastafilename string, header func(name string), base func(b byte)) {
+    fastaFile, err := os.Open(fastafilename)
+    if err != nil { panic(err) }
+    defer fastaFile.Close()
+    reader := bufio.NewReaderSize(fastaFile,1<<20)
+    records := 0
+    for {
+        line, err := reader.ReadSlice('\n')
+        if err != nil && err != io.EOF && err != bufio.ErrBufferFull { panic(err) }
+        if len(line) > 0 && line[0] == '>' {
+            records++
+            if fields := strings.Fields(string(line[1:])); len(fields) > 0 {
+                header(fields[0])
+            } else {
+                header(fmt.Sprintf("unnamed_%d",records))
+            }
+        } else {
+            for _,b := range line {
+                if b >= 'a' && b <= 'z' {
+                    b -= 'a'-'A'
+                }
+                if b >= 'A' && b <= 'Z' {
+                    base(b)
+                }
+            }
+        }
+        if err == io.EOF {
+            return
+        }
+    }
+}
+// KmerWalker() calls visit with the code of every k-mer of the background, skipping k-mers with an N
+// the k-mer is restarted at each new sequence, positions are in the concatenated background
+func KmerWalker(fastafilename string, k int, visit func(code uint64, position uint64)) {
+    var code, position uint64
+    valid := 0 //number of valid bases at the end of the current k-mer
+    mask := uint64(1)<<uint(2*k) - 1
+    ScanFasta(fastafilename,func(name string) { valid = 0 },func(b byte) {
+        c, ok := BASE_CODES[b]
+        if ok {
+            code = (code<<2 | c) & mask
+            valid++
+        } else {
+            valid = 0
+        }
+        position++
+        if valid >= k {
+            visit(code,position-uint64(k))
+        }
+    })
+}
+// BuildOffTargetIndex() builds the on-disk k-mer index of a background fasta
+// the background is read once to count k-mers and pack the sequence and then once
+// per pass to fill in positions, each pass holding at most INDEX_PASS_POSITIONS in memory
+// input: background fasta, index file name and the k-mer (seed) length
+// output: no return, writes the index to indexfilename
+func BuildOffTargetIndex(fastafilename string, indexfilename string, k int) {
+    if k < 4 || k > INDEX_MAX_K {
+        panic(fmt.Sprintf("off-target k-mer length must be in [4,%d]",INDEX_MAX_K))
+    }
+    info, err := os.Stat(fastafilename)
+    if err != nil { panic(err) }
+    outfile, err := os.Create(indexfilename)
+    if err != nil { panic(err) }
+    defer outfile.Close()
+    nkmers := uint64(1) << uint(2*k)
+    sequenceAt := int64(INDEX_HEADER_SIZE) + int64(nkmers+1)*8
+    //first pass, count k-mers, pack the sequence and record sequence names
+    counts := make([]uint32,nkmers)
+    var seqs []BackgroundSeq
+    var masked [][2]uint64
+    var total uint64
+    var packed byte
+    packer := bufio.NewWriterSize(io.NewOffsetWriter(outfile,sequenceAt),1<<20)
+    ScanFasta(fastafilename,func(name string) {
+        seqs = append(seqs,BackgroundSeq{name:name,start:total})
+    },func(b byte) {
+        if _, ok := BASE_CODES[b]; !ok {
+            if n := len(masked); n > 0 && masked[n-1][0]+masked[n-1][1] == total {
+                masked[n-1][1]++
+            } else {
+                masked = append(masked,[2]uint64{total,1})
+            }
+        }
+        packed |= byte(BASE_CODES[b]) << uint(2*(total%4))
+        total++
+        if total%4 == 0 {
+            packer.WriteByte(packed)
+            packed = 0
+        }
+        if len(seqs) > 0 {
+            seqs[len(seqs)-1].length++
+        }
+    })
+    if total%4 != 0 {
+        packer.WriteByte(packed)
+    }
+    if err := packer.Flush(); err != nil { panic(err) }
+    if total > math.MaxUint32 {
+        panic("off-target background must be < 4G bases")
+    }
+    KmerWalker(fastafilename,k,func(code uint64, position uint64) { counts[code]++ })
+    offsets := make([]uint64,nkmers+1)
+    for code := uint64(0); code < nkmers; code++ {
+        offsets[code+1] = offsets[code] + uint64(counts[code])
+    }
+    counts = nil
+    positionsAt := sequenceAt + int64((total+3)/4)
+    //later passes, fill in positions for a range of k-mers at a time
+    for first := uint64(0); first < nkmers; {
+        last := first+1 //k-mers [first,last) are filled in this pass
+        for last < nkmers && offsets[last+1]-offsets[first] <= INDEX_PASS_POSITIONS {
+            last++
+        }
+        positions := make([]uint32,offsets[last]-offsets[first])
+        filled := make([]uint32,last-first)
+        KmerWalker(fastafilename,k,func(code uint64, position uint64) {
+            if code >= first && code < last {
+                positions[offsets[code]-offsets[first]+uint64(filled[code-first])] = uint32(position)
+                filled[code-first]++
+            }
+        })
+        writer := bufio.NewWriterSize(io.NewOffsetWriter(outfile,positionsAt+int64(offsets[first])*4),1<<20)
+        if err := binary.Write(writer,binary.LittleEndian,positions); err != nil { panic(err) }
+        if err := writer.Flush(); err != nil { panic(err) }
+        first = last
+    }
+    //sequence table, masked runs, offsets and header
+    tableAt := positionsAt + int64(offsets[nkmers])*4
+    writer := bufio.NewWriter(io.NewOffsetWriter(outfile,tableAt))
+    for _,seq := range seqs {
+        binary.Write(writer,binary.LittleEndian,uint32(len(seq.name)))
+        writer.WriteString(seq.name)
+        binary.Write(writer,binary.LittleEndian,[2]uint64{seq.start,seq.length})
+    }
+    for _,run := range masked {
+        binary.Write(writer,binary.LittleEndian,run)
+    }
+    if err := writer.Flush(); err != nil { panic(err) }
+    writer = bufio.NewWriterSize(io.NewOffsetWriter(outfile,INDEX_HEADER_SIZE),1<<20)
+    if err := binary.Write(writer,binary.LittleEndian,offsets); err != nil { panic(err) }
+    if err := writer.Flush(); err != nil { panic(err) }
+    header := make([]byte,INDEX_HEADER_SIZE)
+    copy(header,INDEX_MAGIC)
+    binary.LittleEndian.PutUint32(header[8:],uint32(k))
+    binary.LittleEndian.PutUint32(header[12:],uint32(len(seqs)))
+    binary.LittleEndian.PutUint64(header[16:],total)
+    binary.LittleEndian.PutUint64(header[24:],uint64(sequenceAt))
+    binary.LittleEndian.PutUint64(header[32:],uint64(positionsAt))
+    binary.LittleEndian.PutUint64(header[40:],uint64(tableAt))
+    binary.LittleEndian.PutUint64(header[48:],uint64(info.Size()))
+    binary.LittleEndian.PutUint64(header[56:],uint64(info.ModTime().UnixNano()))
+    binary.LittleEndian.PutUint64(header[64:],uint64(len(masked)))
+    if _, err := outfile.WriteAt(header,0); err != nil { panic(err) }
+}
+// OpenOffTargetIndex() opens an index written by BuildOffTargetIndex()
+// only the header, sequence table and masked runs are read into memory
+func OpenOffTargetIndex(indexfilename string) *OffTargetIndex {
+    file, err := os.Open(indexfilename)
+    if err != nil { panic(err) }
+    header := make([]byte,INDEX_HEADER_SIZE)
+    if _, err := file.ReadAt(header,0); err != nil { panic(err) }
+    if string(header[:8]) != INDEX_MAGIC {
+        panic(indexfilename + " is not an off-target index")
+    }
+    idx := &OffTargetIndex{file:file,
+                           path:indexfilename,
+                           k:int(binary.LittleEndian.Uint32(header[8:])),
+                           total:binary.LittleEndian.Uint64(header[16:]),
+                           background_size:int64(binary.LittleEndian.Uint64(header[48:])),
+                           background_time:int64(binary.LittleEndian.Uint64(header[56:])),
+                           offsetsAt:INDEX_HEADER_SIZE,
+                           sequenceAt:int64(binary.LittleEndian.Uint64(header[24:])),
+                           positionsAt:int64(binary.LittleEndian.Uint64(header[32:])),
+                          }
+    nseqs := int(binary.LittleEndian.Uint32(header[12:]))
+    reader := bufio.NewReader(io.NewSectionReader(file,int64(binary.LittleEndian.Uint64(header[40:])),math.MaxInt64/2))
+    for i := 0; i < nseqs; i++ {
+        var length uint32
+        var coords [2]uint64
+        if err := binary.Read(reader,binary.LittleEndian,&length); err != nil { panic(err) }
+        name := make([]byte,length)
+        if _, err := io.ReadFull(reader,name); err != nil { panic(err) }
+        if err := binary.Read(reader,binary.LittleEndian,&coords); err != nil { panic(err) }
+        idx.seqs = append(idx.seqs,BackgroundSeq{name:string(name),start:coords[0],length:coords[1]})
+    }
+    idx.masked = make([][2]uint64,binary.LittleEndian.Uint64(header[64:]))
+    if err := binary.Read(reader,binary.LittleEndian,idx.masked); err != nil { panic(err) }
+    return idx
+}
+// Positions() every background position of a k-mer, nil for repeats
+func (idx *OffTargetIndex) Positions(code uint64) []uint32 {
+    var bounds [2]uint64
+    buf := make([]byte,16)
+    if _, err := idx.file.ReadAt(buf,idx.offsetsAt+int64(code)*8); err != nil { panic(err) }
+    bounds[0], bounds[1] = binary.LittleEndian.Uint64(buf), binary.LittleEndian.Uint64(buf[8:])
+    n := bounds[1]-bounds[0]
+    if n == 0 || n > INDEX_MAX_OCCURRENCES {
+        return nil
+    }
+    positions := make([]uint32,n)
+    section := io.NewSectionReader(idx.file,idx.positionsAt+int64(bounds[0])*4,int64(n)*4)
+    if err := binary.Read(section,binary.LittleEndian,positions); err != nil { panic(err) }
+    return positions
+}
+// Window() the background bases [start,start+length), unpacked, masked bases are N
+func (idx *OffTargetIndex) Window(start uint64, length int) string {
+    first, last := start/4, (start+uint64(length)+3)/4
+    packed := make([]byte,last-first)
+    if _, err := idx.file.ReadAt(packed,idx.sequenceAt+int64(first)); err != nil && err != io.EOF { panic(err) }
+    window := make([]byte,length)
+    for i := range window {
+        p := start+uint64(i)
+        window[i] = byte(DNA_ALPHABET[(packed[p/4-first] >> uint(2*(p%4))) & 3])
+    }
+    end := start+uint64(length)
+    for r := sort.Search(len(idx.masked),func(r int) bool { return idx.masked[r][0]+idx.masked[r][1] > start }); r < len(idx.masked) && idx.masked[r][0] < end; r++ {
+        from, to := idx.masked[r][0], idx.masked[r][0]+idx.masked[r][1]
+        if from < start {
+            from = start
+        }
+        for p := from; p < end && p < to; p++ {
+            window[p-start] = 'N'
+        }
+    }
+    return string(window)
+}
+// Locate() the background sequence a window falls in, -1 if it spans 2 sequences
+func (idx *OffTargetIndex) Locate(start uint64, length int) int {
+    i := sort.Search(len(idx.seqs),func(i int) bool { return idx.seqs[i].start > start }) - 1
+    if i < 0 || start+uint64(length) > idx.seqs[i].start+idx.seqs[i].length {
+        return -1
+    }
+    return i
+}
+// Search() every background window of the query length with at most maxMismatches to the query
+// each N free part of the query (each arm of a binding site) is seeded on its own with its exact
+// k-mers, which finds every such window if the parts hold maxMismatches+1 non-overlapping k-mers,
+// see SeedLength(), N in the query matches any base and masked background bases match none
+// output: map of background start position to mismatch count
+func (idx *OffTargetIndex) Search(query string, maxMismatches int) map[uint64]int {
+    hits := make(map[uint64]int)
+    tried := make(map[uint64]bool)
+    offset := 0 //of the part in the query
+    for _,part := range strings.Split(query,"N") {
+        for o := offset; o+idx.k <= offset+len(part); o++ {
+            code, ok := uint64(0), true
+            for _,b := range []byte(query[o:o+idx.k]) {
+                c, valid := BASE_CODES[b]
+                ok = ok && valid
+                code = code<<2 | c
+            }
+            if !ok {
+                continue
+            }
+            for _,p := range idx.Positions(code) {
+                if uint64(p) < uint64(o) || tried[uint64(p)-uint64(o)] {
+                    continue
+                }
+                start := uint64(p)-uint64(o)
+                tried[start] = true
+                if idx.Locate(start,len(query)) < 0 {
+                    continue
+                }
+                window := idx.Window(start,len(query))
+                mismatches := 0
+                for i := range window {
+                    if window[i] != query[i] && query[i] != 'N' {
+                        mismatches++
+                    }
+                }
+                if mismatches <= maxMismatches {
+                    hits[start] = mismatches
+                }
+            }
+        }
+        offset += len(part)+1
+    }
+    return hits
+}
+// SeedLength() the longest seed k-mer that finds every off-target site with at most maxMismatches
+// sites are seeded part by part, so the parts of the shortest binding site must hold
+// maxMismatches+1 non-overlapping k-mers
+// input: lengths of the parts of the shortest binding site (the 2 arms, or the whole member),
+//        the mismatch limit and the -offtarget_k asked for, 0 for the longest
+// output: k-mer length, panics if no k in [4,INDEX_MAX_K] or not the k asked for finds every site
+func SeedLength(parts []int, maxMismatches int, k int) int {
+    longest := 0
+    for s := 4; s <= INDEX_MAX_K; s++ {
+        seeds := 0
+        for _,length := range parts {
+            seeds += length/s
+        }
+        if seeds > maxMismatches {
+            longest = s
+        }
+    }
+    switch {
+        case longest == 0:
+            panic(fmt.Sprintf("off-target sites of %v bases with %d mismatches cannot all be found by seeds of 4 or more bases, lower -offtarget_mismatches",parts,maxMismatches))
+        case k == 0:
+            return longest
+        case k > longest:
+            panic(fmt.Sprintf("-offtarget_k %d misses off-target sites of %v bases with %d mismatches, it must be at most %d",k,parts,maxMismatches,longest))
+    }
+    return k
+}
+// SiteParts() lengths of the parts of the shortest binding site members can have
+// input: template (nil for none) and the shortest member
+// output: the shortest arm lengths for a template with arm5 and arm3 regions, else the shortest member
+func SiteParts(template *Template, shortest int) []int {
+    if template != nil {
+        var arms []int
+        for _,region := range template.regions {
+            if region.name == "arm5" || region.name == "arm3" {
+                arms = append(arms,region.min)
+            }
+        }
+        if len(arms) == 2 {
+            return arms
+        }
+    }
+    return []int{shortest}
+}
+// Screen() off-target hits of a member, best binding first
+// both strands of the background are searched for the site the member binds, for a templated
+// member the site its arms bind (see BindingSite()) as the catalytic core does not pair,
+// otherwise the whole member's reverse complement
+// hits identical to part of the target are on-target and skipped
+// input: member sequence, binding site ("" for the whole member), target 5'->3', buffer conditions and the mismatch limit
+// output: hits sorted by dG, lowest first
+func (idx *OffTargetIndex) Screen(seq string, site string, target string, c Conditions, maxMismatches int) []OffTargetHit {
+    var hits []OffTargetHit
+    seq, target = strings.ToUpper(seq), strings.ToUpper(target)
+    if site == "" {
+        site = ReverseComplement(seq)
+    }
+    for _,strand := range []byte{'+','-'} {
+        query := site
+        if strand == '-' {//the complement strand binds where the background matches the reverse complement of the site
+            query = ReverseComplement(site)
+        }
+        for start,mismatches := range idx.Search(query,maxMismatches) {
+            bound := idx.Window(start,len(query))
+            if strand == '-' {
+                bound = ReverseComplement(bound)
+            }
+            if strings.Contains(target,bound) {//the target itself
+                continue
+            }
+            s := idx.seqs[idx.Locate(start,len(query))]
+            hits = append(hits,OffTargetHit{name:s.name,
+                                            position:start-s.start,
+                                            strand:strand,
+                                            mismatches:mismatches,
+                                            dG:BestDuplex(seq,bound,c).dG})
+        }
+    }
+    sort.Slice(hits,func(i,j int) bool {
+        if hits[i].dG != hits[j].dG {
+            return hits[i].dG < hits[j].dG
+        }
+        return hits[i].name < hits[j].name || (hits[i].name == hits[j].name && hits[i].position < hits[j].position)
+    })
+    return hits
+}
+
+// OffTargetTerm penalises members that bind the background about as well as the target
+// the margin is the dG of the best off-target hit minus the dG on target, and the
+// score is 1-exp(-margin/10), 0 when an off-target binds at least as well and 1 with no hits
+type OffTargetTerm struct {
+    index *OffTargetIndex
+    target string
+    conditions Conditions
+    maxMismatches int
+    template *Template //nil to screen whole members
+}
+func (t OffTargetTerm) Name() string { return "offtarget" }
+func (t OffTargetTerm) Score(pop Population) []float64 {
+    scores := make([]float64,len(pop))
+    for i,member := range pop {
+        onTarget := member.Duplex(t.target,t.conditions).dG
+        hits := t.index.Screen(member.seq,t.template.BindingSite(member.seq,member.segments),t.target,t.conditions,t.maxMismatches)
+        pop[i].Annotate("offtarget_hits",float64(len(hits)))
+        if len(hits) == 0 {
+            scores[i] = 1
+            pop[i].Annotate("offtarget_dG",0)
+            pop[i].Annotate("offtarget_mismatches",-1)
+            pop[i].Annotate("offtarget_margin",-onTarget)
+            continue
+        }
+        margin := hits[0].dG - onTarget
+        scores[i] = 1 - math.Exp(-math.Max(0,margin)/DUPLEX_SCALE)
+        pop[i].Annotate("offtarget_dG",hits[0].dG)
+        pop[i].Annotate("offtarget_mismatches",float64(hits[0].mismatches))
+        pop[i].Annotate("offtarget_margin",margin)
+    }
+    return scores
+}
+
+// IndexMagic() the first 8 bytes of an index file, its format version
+func IndexMagic(indexfilename string) string {
+    file, err := os.Open(indexfilename)
+    if err != nil { panic(err) }
+    defer file.Close()
+    magic := make([]byte,len(INDEX_MAGIC))
+    n, _ := io.ReadFull(file,magic)
+    return string(magic[:n])
+}
+// OpenBackground() opens the off-target index of a background fasta, building it if needed
+// an index built with another k-mer length or from a background with another size or
+// modification time is stale and rebuilt
+// input: background fasta, index file name ("" for background.kidx) and the k-mer length
+// output: open index
+func OpenBackground(background string, indexfilename string, k int) *OffTargetIndex {
+    if indexfilename == "" {
+        indexfilename = background + ".kidx"
+    }
+    info, err := os.Stat(background)
+    if err != nil { panic(err) }
+    _, err = os.Stat(indexfilename)
+    switch {
+        case os.IsNotExist(err):
+            fmt.Println("Building off-target index ",indexfilename)
+        case err != nil:
+            panic(err)
+        case IndexMagic(indexfilename) != INDEX_MAGIC && strings.HasPrefix(IndexMagic(indexfilename),INDEX_MAGIC_PREFIX):
+            fmt.Println("Off-target index ",indexfilename," was built by an older version, rebuilding it")
+        default:
+            idx := OpenOffTargetIndex(indexfilename)
+            if idx.k == k && idx.background_size == info.Size() && idx.background_time == info.ModTime().UnixNano() {
+                return idx
+            }
+            idx.file.Close()
+            fmt.Println("Off-target index ",indexfilename," was built with another -offtarget_k or background, rebuilding it")
+    }
+    BuildOffTargetIndex(background,indexfilename,k)
+    return OpenOffTargetIndex(indexfilename)
+}
+// WriteOffTargetReport() writes the top off-target hits of every member
+// input: population, open index, fitness context with the target, number of hits per member
+// output: no return, writes a tsv with one hit per line
+func (pop Population) WriteOffTargetReport(filename string, idx *OffTargetIndex, ctx FitnessContext, top int) {
+    outfile,err := os.Create(filename)
+    if err != nil {
+        panic(err)
+    }
+    defer outfile.Close()
+    outfile.WriteString("SeqLabel\tSequence\tOnTarget_dG\tHit\tBackgroundSeq\tPosition\tStrand\tMismatches\tdG\tMargin\n")
+    for _,member := range pop {
+        onTarget := member.Duplex(ctx.target_seq,ctx.conditions).dG
+        hits := idx.Screen(member.seq,ctx.template.BindingSite(member.seq,member.segments),ctx.target_seq,ctx.conditions,ctx.offtarget_mismatches)
+        for i,hit := range hits[:Min(top,len(hits))] {
+            line := fmt.Sprintf("Sequence_%d\t%s\t%f\t%d\t%s\t%d\t%c\t%d\t%f\t%f\n",member.label,member.seq,onTarget,i+1,hit.name,hit.position+1,hit.strand,hit.mismatches,hit.dG,hit.dG-onTarget)
+            outfile.WriteString(line)
+        }
+    }
+}
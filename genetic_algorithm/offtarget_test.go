@@ -0,0 +1,119 @@
+package main
+
+import(
+    "os"
+    "strings"
+    "testing"
+    "math/rand"
+    "path/filepath"
+)
+
+var TEST_CONDITIONS = Conditions{na:150,mg:10,oligo:1000,temperature:37}
+
+// RandomDNA() a random DNA string for tests
+func RandomDNA(r *rand.Rand, n int) string {
+    seq := make([]byte,n)
+    for i := range seq {
+        seq[i] = byte(DNA_ALPHABET[r.Intn(4)])
+    }
+    return string(seq)
+}
+// Mutate1() the sequence with the base at i changed
+func Mutate1(seq string, i int) string {
+    b := []byte(seq)
+    b[i] = byte(DNA_COMPLEMENTS[rune(b[i])])
+    return string(b)
+}
+// IndexedBackground() indexes a background fasta written from the records, "" for a bare > header
+func IndexedBackground(t *testing.T, names []string, seqs []string, parts []int, maxMismatches int) *OffTargetIndex {
+    var fasta strings.Builder
+    for i,seq := range seqs {
+        fasta.WriteString(">" + names[i] + "\n" + seq + "\n")
+    }
+    background := filepath.Join(t.TempDir(),"background.fna")
+    if err := os.WriteFile(background,[]byte(fasta.String()),0644); err != nil { t.Fatal(err) }
+    return OpenBackground(background,"",SeedLength(parts,maxMismatches,0))
+}
+// FindHit() the hit at a background sequence, position and strand, nil if there is none
+func FindHit(hits []OffTargetHit, name string, position uint64, strand byte) *OffTargetHit {
+    for i,hit := range hits {
+        if hit.name == name && hit.position == position && hit.strand == strand {
+            return &hits[i]
+        }
+    }
+    return nil
+}
+
+// TestScreenPlantedSite checks a site bound by a 10-23 member's arms, planted on both strands
+// with a mismatch, is found although the catalytic core does not match the background
+func TestScreenPlantedSite(t *testing.T) {
+    r := rand.New(rand.NewSource(1))
+    template := ParseTemplate("10-23")
+    site := RandomDNA(r,19) //9 bases bound by arm3, the junction base, 9 bases bound by arm5
+    seq := ReverseComplement(site[10:]) + template.regions[1].seq + ReverseComplement(site[:9])
+    if bound := template.BindingSite(seq,nil); bound != site[:9]+"N"+site[10:] {
+        t.Fatalf("binding site %s, want %s",bound,site[:9]+"N"+site[10:])
+    }
+    target := RandomDNA(r,200) + site + RandomDNA(r,200)
+    offtarget := Mutate1(site,2)
+    idx := IndexedBackground(t,[]string{"chr1",""},
+                             []string{RandomDNA(r,3000) + offtarget + RandomDNA(r,3000),
+                                      RandomDNA(r,2000) + ReverseComplement(offtarget) + RandomDNA(r,2000)},
+                             SiteParts(template,10),1)
+    if idx.k != 7 {
+        t.Errorf("seed length %d for 7 base arms and 1 mismatch, want 7",idx.k)
+    }
+    hits := idx.Screen(seq,template.BindingSite(seq,nil),target,TEST_CONDITIONS,1)
+    for _,want := range []OffTargetHit{{name:"chr1",position:3000,strand:'+'},{name:"unnamed_2",position:2000,strand:'-'}} {
+        hit := FindHit(hits,want.name,want.position,want.strand)
+        switch {
+            case hit == nil:
+                t.Errorf("planted site %s:%d%c not found in %v",want.name,want.position,want.strand,hits)
+            case hit.mismatches != 1:
+                t.Errorf("planted site %s:%d%c has %d mismatches, want 1",want.name,want.position,want.strand,hit.mismatches)
+        }
+    }
+    if whole := idx.Screen(seq,"",target,TEST_CONDITIONS,1); FindHit(whole,"chr1",3000,'+') != nil {
+        t.Error("whole member search found a site only the arms bind")
+    }
+}
+
+// TestScreenMaskedN checks an N in the background matches no base
+func TestScreenMaskedN(t *testing.T) {
+    r := rand.New(rand.NewSource(2))
+    template := ParseTemplate("10-23")
+    site := RandomDNA(r,19)
+    seq := ReverseComplement(site[10:]) + template.regions[1].seq + ReverseComplement(site[:9])
+    target := RandomDNA(r,200) + site + RandomDNA(r,200)
+    masked := site[:2] + "N" + site[3:]
+    for _,maxMismatches := range []int{0,1} {
+        idx := IndexedBackground(t,[]string{"chr1"},[]string{RandomDNA(r,1000) + masked + RandomDNA(r,1000)},SiteParts(template,10),maxMismatches)
+        hit := FindHit(idx.Screen(seq,template.BindingSite(seq,nil),target,TEST_CONDITIONS,maxMismatches),"chr1",1000,'+')
+        switch {
+            case maxMismatches == 0 && hit != nil:
+                t.Error("site with an N found with no mismatches allowed")
+            case maxMismatches == 1 && (hit == nil || hit.mismatches != 1):
+                t.Errorf("site with an N is %v with 1 mismatch allowed, want 1 mismatch",hit)
+        }
+        if window := idx.Window(1000,19); window != masked {
+            t.Errorf("window %s, want %s",window,masked)
+        }
+    }
+}
+
+// TestSeedLength checks seeds are short enough to find every site
+func TestSeedLength(t *testing.T) {
+    for _,c := range []struct{ parts []int; mismatches, k, want int }{
+        {[]int{7,7},1,0,7},
+        {[]int{7,7},0,0,7},
+        {[]int{13,13},3,0,6},
+        {[]int{40},3,0,10},
+        {[]int{9,9},1,8,8},
+    } {
+        if k := SeedLength(c.parts,c.mismatches,c.k); k != c.want {
+            t.Errorf("seed length %d for %v with %d mismatches, want %d",k,c.parts,c.mismatches,c.want)
+        }
+    }
+    ExpectPanic(t,"lower -offtarget_mismatches",func() { SeedLength([]int{7,7},3,0) })
+    ExpectPanic(t,"must be at most 9",func() { SeedLength([]int{9,9},1,12) })
+}
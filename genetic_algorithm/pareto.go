@@ -0,0 +1,136 @@
+package main
+
+import(
+    "sort"
+)
+
+//Multi-objective selection (NSGA-II, Deb et al. 2002)
+// Objectives() the score of every objective for a member, in the order given
+// input: names of the fitness terms used as objectives
+// output: objective vector, every objective is maximised
+func (s Member) Objectives(objectives []string) []float64 {
+    vector := make([]float64,len(objectives))
+    for i,name := range objectives {
+        vector[i] = s.scores[name]
+    }
+    return vector
+}
+// Dominates() checks if objective vector a Pareto dominates b
+// a dominates b if it is no worse in every objective and better in at least one
+func Dominates(a,b []float64) bool {
+    better := false
+    for i := range a {
+        if a[i] < b[i] {
+            return false
+        } else if a[i] > b[i] {
+            better = true
+        }
+    }
+    return better
+}
+// NonDominatedSort() splits a population into Pareto fronts
+// input: population and the objectives to consider
+// output: list of fronts, each a list of indices into pop, best front first
+func (pop Population) NonDominatedSort(objectives []string) [][]int {
+    vectors := make([][]float64,len(pop))
+    for i,member := range pop {
+        vectors[i] = member.Objectives(objectives)
+    }
+    dominatedBy := make([]int,len(pop)) //how many members dominate i
+    dominates := make([][]int,len(pop)) //members that i dominates
+    var fronts [][]int
+    var front []int
+    for i := range pop {
+        for j := i+1; j < len(pop); j++ {
+            if Dominates(vectors[i],vectors[j]) {
+                dominates[i] = append(dominates[i],j)
+                dominatedBy[j]++
+            } else if Dominates(vectors[j],vectors[i]) {
+                dominates[j] = append(dominates[j],i)
+                dominatedBy[i]++
+            }
+        }
+    }
+    for i := range pop {
+        if dominatedBy[i] == 0 {
+            front = append(front,i)
+        }
+    }
+    for len(front) > 0 {//peel off fronts untill every member is assigned
+        fronts = append(fronts,front)
+        var next []int
+        for _,i := range front {
+            for _,j := range dominates[i] {
+                dominatedBy[j]--
+                if dominatedBy[j] == 0 {
+                    next = append(next,j)
+                }
+            }
+        }
+        front = next
+    }
+    return fronts
+}
+// CrowdingDistance() crowding distance of each member of a single front
+// boundary members of each objective get an infinite distance so they are kept
+// input: population, indices of the front and the objectives
+// output: no return, crowding is assigned for front members inplace
+func (pop Population) CrowdingDistance(front []int, objectives []string) {
+    for _,i := range front {
+        pop[i].crowding = 0
+    }
+    if len(front) < 3 {
+        for _,i := range front {
+            pop[i].crowding = INF
+        }
+        return
+    }
+    sorted := make([]int,len(front))
+    for _,name := range objectives {
+        copy(sorted,front)
+        sort.Slice(sorted,func(a,b int) bool { return pop[sorted[a]].scores[name] < pop[sorted[b]].scores[name] })
+        low := pop[sorted[0]].scores[name]
+        high := pop[sorted[len(sorted)-1]].scores[name]
+        pop[sorted[0]].crowding = INF
+        pop[sorted[len(sorted)-1]].crowding = INF
+        if high == low {//every member is identical in this objective
+            continue
+        }
+        for k := 1; k < len(sorted)-1; k++ {
+            gap := pop[sorted[k+1]].scores[name] - pop[sorted[k-1]].scores[name]
+            pop[sorted[k]].crowding += gap/(high-low)
+        }
+    }
+}
+// AssignParetoRanks() ranks every member by Pareto front (1 is the best front)
+// and assigns the crowding distance within each front
+// input: objectives to consider, the fitness term names
+// output: no return, rank and crowding are assigned inplace
+func (pop Population) AssignParetoRanks(objectives []string) {
+    for rank,front := range pop.NonDominatedSort(objectives) {
+        for _,i := range front {
+            pop[i].rank = rank+1
+        }
+        pop.CrowdingDistance(front,objectives)
+    }
+}
+// SortByPareto() returns pop sorted by front rank and then crowding distance
+// so the best members are at the end, like SortByFitness()
+func (pop Population) SortByPareto() Population {
+    sort.SliceStable(pop,func(i,j int) bool {
+        if pop[i].rank != pop[j].rank {
+            return pop[i].rank > pop[j].rank
+        }
+        return pop[i].crowding < pop[j].crowding
+    })
+    return pop
+}
+// GetParetoFittestMembers() NSGA-II replacement for GetFittestMembers()
+// selects the top members by non-dominated sorting and crowding distance
+// input: a population, the objectives and the proportion of members to keep
+// output: the top proportion percent of the generation
+func GetParetoFittestMembers(generation Population, objectives []string, top_sequence_percent float64) Population {
+    generation.AssignParetoRanks(objectives)
+    index := len(generation) - int(float64(len(generation))*top_sequence_percent)
+    return generation.SortByPareto()[index:len(generation)]
+}
@@ -0,0 +1,221 @@
+package main
+
+import(
+    "io"
+    "os"
+    "fmt"
+    "sync"
+    "time"
+    "bufio"
+    "syscall"
+    "os/exec"
+    "encoding/json"
+)
+
+//Persistent python classifier
+//pickled models are scored by one long lived `dnazyme_classifier.py --serve` process
+//per model instead of a new interpreter every generation, sequences are sent as
+//newline delimited json over stdin and predictions come back over stdout
+//the worker is pinged before every batch and restarted if it died, hung or timed out
+//workers run in their own process group so Ctrl-C only reaches the simulation, which
+//finishes the current generation and then stops them
+
+const WORKER_PING_TIMEOUT = 5*time.Second
+const WORKER_RETRIES = 1 //times a failed batch is retried on a restarted worker
+
+// WorkerRequest is one line sent to the worker
+type WorkerRequest struct {
+    Id int `json:"id"`
+    Ping bool `json:"ping,omitempty"`
+    Seqs []string `json:"seqs,omitempty"`
+}
+// WorkerResponse is one line sent back by the worker
+type WorkerResponse struct {
+    Id int `json:"id"`
+    Ready bool `json:"ready"`
+    Pong bool `json:"pong"`
+    Predictions []float64 `json:"predictions"`
+    Error string `json:"error"`
+}
+// PythonWorker is a running classifier process for one pickled model
+type PythonWorker struct {
+    model_file string
+    timeout time.Duration //longest wait for a batch (and for the model to load)
+    cmd *exec.Cmd
+    stdin io.WriteCloser
+    responses chan WorkerResponse //closed when the process exits
+    next int //id of the next request
+    lock sync.Mutex //one batch at a time
+}
+
+//running workers by model file
+var pythonWorkers = map[string]*PythonWorker{}
+var pythonWorkersLock sync.Mutex
+//process group (the pid) of every running worker process, read by KillPythonWorkers() without
+//pythonWorkersLock, which GetPythonWorker() holds while a worker loads its model
+var workerGroups sync.Map
+
+// GetPythonWorker() the worker for a model, starting it the first time
+// input: pickled model file and the batch timeout
+// output: pointer to the running worker
+func GetPythonWorker(model_file string, timeout time.Duration) *PythonWorker {
+    pythonWorkersLock.Lock()
+    defer pythonWorkersLock.Unlock()
+    if worker, ok := pythonWorkers[model_file]; ok {
+        return worker
+    }
+    worker := &PythonWorker{model_file:model_file,timeout:timeout}
+    if err := worker.Start(); err != nil { panic(err) }
+    pythonWorkers[model_file] = worker
+    return worker
+}
+// StopPythonWorkers() shuts down every running worker, at the end of a run
+func StopPythonWorkers() {
+    pythonWorkersLock.Lock()
+    defer pythonWorkersLock.Unlock()
+    for model_file,worker := range pythonWorkers {
+        worker.Stop()
+        delete(pythonWorkers,model_file)
+    }
+}
+// KillPythonWorkers() kills the process group of every running worker at once, when the run exits
+// without finishing, including workers still loading their model
+func KillPythonWorkers() {
+    workerGroups.Range(func(pid, _ interface{}) bool {
+        syscall.Kill(-pid.(int),syscall.SIGKILL)
+        return true
+    })
+}
+
+// Start() launches the worker process and waits for it to load the model
+func (w *PythonWorker) Start() error {
+    w.cmd = exec.Command(python_exe,classifer_script,"--serve",w.model_file)
+    w.cmd.Stderr = os.Stderr
+    w.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid:true} //not sent the terminal's SIGINT
+    stdin, err := w.cmd.StdinPipe()
+    if err != nil { return err }
+    stdout, err := w.cmd.StdoutPipe()
+    if err != nil { return err }
+    if err := w.cmd.Start(); err != nil { return err }
+    workerGroups.Store(w.cmd.Process.Pid,true)
+    w.stdin = stdin
+    responses := make(chan WorkerResponse)
+    w.responses = responses
+    go func() {//read responses until the process exits
+        defer close(responses)
+        scanner := bufio.NewScanner(stdout)
+        scanner.Buffer(make([]byte,1<<16),1<<26)
+        for scanner.Scan() {
+            var response WorkerResponse
+            if err := json.Unmarshal(scanner.Bytes(),&response); err != nil {
+                response.Id, response.Error = -1, "invalid response " + scanner.Text()
+            }
+            responses <- response
+        }
+    }()
+    select {
+        case response, ok := <-responses:
+            if !ok || !response.Ready {
+                w.Kill()
+                return fmt.Errorf("classifier worker for %s did not start: %s",w.model_file,response.Error)
+            }
+        case <-time.After(w.timeout):
+            w.Kill()
+            return fmt.Errorf("classifier worker for %s did not load the model within %v",w.model_file,w.timeout)
+    }
+    return nil
+}
+// Kill() stops the worker process and any children it started immediately
+func (w *PythonWorker) Kill() {
+    syscall.Kill(-w.cmd.Process.Pid,syscall.SIGKILL)
+    for range w.responses {} //drain so the reader exits
+    w.Wait()
+}
+// Wait() waits for the worker process to exit
+func (w *PythonWorker) Wait() {
+    w.cmd.Wait()
+    workerGroups.Delete(w.cmd.Process.Pid)
+}
+// Stop() closes the worker's stdin so it exits, killing it if it does not
+func (w *PythonWorker) Stop() {
+    w.stdin.Close()
+    exited := make(chan bool)
+    go func() {
+        for range w.responses {}
+        exited <- true
+    }()
+    select {
+        case <-exited:
+            w.Wait()
+        case <-time.After(WORKER_PING_TIMEOUT):
+            w.Kill()
+    }
+}
+// Restart() replaces a dead or stuck worker process
+func (w *PythonWorker) Restart() {
+    fmt.Println("Restarting classifier worker for ",w.model_file)
+    w.Kill()
+    if err := w.Start(); err != nil { panic(err) }
+}
+// Request() sends one request and waits for its response
+// input: request (the id is filled in) and the longest time to wait
+// output: response, error if the worker died, timed out or reported an error
+func (w *PythonWorker) Request(request WorkerRequest, timeout time.Duration) (WorkerResponse, error) {
+    w.next++
+    request.Id = w.next
+    line, err := json.Marshal(request)
+    if err != nil { panic(err) }
+    if _, err := w.stdin.Write(append(line,'\n')); err != nil {
+        return WorkerResponse{}, err
+    }
+    deadline := time.After(timeout)
+    for {
+        select {
+            case response, ok := <-w.responses:
+                switch {
+                    case !ok:
+                        return response, fmt.Errorf("classifier worker exited")
+                    case response.Id != request.Id://late answer to a timed out request
+                        continue
+                    case response.Error != "":
+                        return response, fmt.Errorf("classifier worker error: %s",response.Error)
+                }
+                return response, nil
+            case <-deadline:
+                return WorkerResponse{}, fmt.Errorf("classifier worker timed out after %v",timeout)
+        }
+    }
+}
+// Healthy() pings the worker
+func (w *PythonWorker) Healthy() bool {
+    response, err := w.Request(WorkerRequest{Ping:true},WORKER_PING_TIMEOUT)
+    return err == nil && response.Pong
+}
+// Predict() DNAzyme probability of every member from the worker
+// the worker is restarted if it fails the health check or the batch fails,
+// and the batch retried WORKER_RETRIES times before giving up
+func (w *PythonWorker) Predict(pop Population) []float64 {
+    w.lock.Lock()
+    defer w.lock.Unlock()
+    seqs := make([]string,len(pop))
+    for i,member := range pop {
+        seqs[i] = member.seq
+    }
+    for try := 0; ; try++ {
+        if !w.Healthy() {
+            w.Restart()
+        }
+        response, err := w.Request(WorkerRequest{Seqs:seqs},w.timeout)
+        if err == nil && len(response.Predictions) != len(pop) {
+            err = fmt.Errorf("classifier worker returned %d predictions for %d sequences",len(response.Predictions),len(pop))
+        }
+        if err == nil {
+            return response.Predictions
+        }
+        if try == WORKER_RETRIES {
+            panic(err)
+        }
+        fmt.Println(err)
+        w.Restart()
+    }
+}
@@ -0,0 +1,37 @@
+package main
+
+import(
+    "io"
+    "time"
+    "bufio"
+    "syscall"
+    "testing"
+    "os/exec"
+)
+
+// TestKillPythonWorkers checks the whole process group of a worker is killed while
+// GetPythonWorker() holds pythonWorkersLock
+func TestKillPythonWorkers(t *testing.T) {
+    w := &PythonWorker{cmd:exec.Command("sh","-c","sleep 60 & echo started; wait")} //a worker with a child
+    w.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid:true}
+    stdout, err := w.cmd.StdoutPipe()
+    if err != nil { t.Fatal(err) }
+    if err := w.cmd.Start(); err != nil { t.Fatal(err) }
+    workerGroups.Store(w.cmd.Process.Pid,true)
+    defer w.Wait()
+    reader := bufio.NewReader(stdout)
+    if _, err := reader.ReadString('\n'); err != nil { t.Fatal(err) }
+    pythonWorkersLock.Lock() //a worker loading its model
+    defer pythonWorkersLock.Unlock()
+    killed := make(chan bool)
+    go func() {
+        KillPythonWorkers()
+        io.ReadAll(reader) //EOF once the shell and sleep have both exited
+        killed <- true
+    }()
+    select {
+        case <-killed:
+        case <-time.After(5*time.Second):
+            t.Fatal("worker process group still running 5s after KillPythonWorkers")
+    }
+}
@@ -0,0 +1,73 @@
+package main
+
+import(
+    "sync"
+    "time"
+    "math/rand"
+)
+
+//Random numbers
+//every random number is drawn from a CountingSource, not the math/rand functions, so the state
+//of the generator is known and a checkpoint can save and restore it, see checkpoint.go
+//the source is the math/rand source, so a -seed gives the same numbers it always has,
+//and it counts the numbers drawn, restoring seeds a new source and skips that many numbers
+//RNG draws the initial population, migrations and SELEX rounds, every island breeds with its own
+//source seeded from the seed of RANDOM_SOURCE and the island id, so islands evolving in parallel
+//do not share a generator and the order they run in does not change the numbers each one draws
+
+// CountingSource is a math/rand source that counts the numbers drawn, safe for concurrent use
+type CountingSource struct {
+    source rand.Source64
+    seed int64
+    draws uint64 //numbers drawn since seeding
+    lock sync.Mutex
+}
+
+var RANDOM_SOURCE = NewCountingSource(time.Now().UnixNano()) //seeded with -seed if given
+var RNG = rand.New(RANDOM_SOURCE) //methods other than Read are safe for concurrent use
+
+// IslandSeed() seed of an island's source, from the seed of RANDOM_SOURCE and the island id
+// offset by one so island 0 does not repeat the numbers RNG draws
+func IslandSeed(seed int64, id int) int64 {
+    return seed + int64(id) + 1
+}
+// NewCountingSource() a source seeded with seed
+func NewCountingSource(seed int64) *CountingSource {
+    s := &CountingSource{}
+    s.Seed(seed)
+    return s
+}
+func (s *CountingSource) Seed(seed int64) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.source = rand.NewSource(seed).(rand.Source64)
+    s.seed = seed
+    s.draws = 0
+}
+func (s *CountingSource) Int63() int64 {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.draws++
+    return s.source.Int63()
+}
+func (s *CountingSource) Uint64() uint64 {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.draws++
+    return s.source.Uint64()
+}
+// State() seed and numbers drawn since seeding
+func (s *CountingSource) State() (int64, uint64) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    return s.seed, s.draws
+}
+// Restore() puts the source in the state State() returned
+func (s *CountingSource) Restore(seed int64, draws uint64) {
+    s.Seed(seed)
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    for ; s.draws < draws; s.draws++ {
+        s.source.Uint64()
+    }
+}
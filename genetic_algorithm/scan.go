@@ -0,0 +1,139 @@
+package main
+
+import(
+    "os"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+//Target scanning
+//enumerates every cleavage junction of the target, designs the canonical
+//DNAzyme for each junction and arm lengths, and ranks the designs by fitness
+
+//IUPAC codes usable in a template junction motif
+var IUPAC_CODES = map[byte]string{
+    'A':"A", 'C':"C", 'G':"G", 'T':"T",
+    'R':"AG", 'Y':"CT", 'S':"CG", 'W':"AT", 'K':"GT", 'M':"AC",
+    'B':"CGT", 'D':"AGT", 'H':"ACT", 'V':"ACG", 'N':"ACGT",
+}
+
+// Design is a DNAzyme designed against one cleavage junction of the target
+type Design struct {
+    position int //0 based position of the first base of the junction in the target
+    junction string //target dinucleotide that is cleaved
+    arm5 int //length of the 5' binding arm
+    arm3 int //length of the 3' binding arm
+}
+
+// MatchesIUPAC() checks if a base matches an IUPAC nucleotide code
+func MatchesIUPAC(base byte, code byte) bool {
+    return strings.IndexByte(IUPAC_CODES[code],base) >= 0
+}
+// FindCleavageSites() positions of every junction in the target matching the motif
+// input: target sequence 5'->3' and the dinucleotide junction motif, e.g. RY
+// output: 0 based positions of the first base of every matching junction
+func FindCleavageSites(target string, junction string) []int {
+    var sites []int
+    for i := 0; i+1 < len(target); i++ {
+        if MatchesIUPAC(target[i],junction[0]) && MatchesIUPAC(target[i+1],junction[1]) {
+            sites = append(sites,i)
+        }
+    }
+    return sites
+}
+// Name() design name in the style of Abdelgany et al., e.g. 463GT(13+9)
+// the position is 1 based and the arms are listed in target order, so the
+// 3' arm (binding upstream of the junction) comes first
+func (d Design) Name() string {
+    return fmt.Sprintf("%d%s(%d+%d)",d.position+1,d.junction,d.arm3,d.arm5)
+}
+// Segments() region sequences of the canonical DNAzyme for this design
+// the first base of the junction is left unpaired, the 5' arm pairs with the
+// target from the second base of the junction onwards and the 3' arm pairs
+// with the target just before the junction
+// input: target 5'->3' and a template of the form arm,core,arm
+// output: sequences of the 5' arm, core and 3' arm
+func (d Design) Segments(target string, template *Template) []string {
+    arm5 := ReverseComplement(target[d.position+1:d.position+1+d.arm5])
+    arm3 := ReverseComplement(target[d.position-d.arm3:d.position])
+    return []string{arm5,template.regions[1].seq,arm3}
+}
+// DesignDNAzymes() designs a DNAzyme for every cleavage junction of the target and arm lengths
+// input: target 5'->3', template of the form arm,core,arm, arm length bounds and
+//        the largest allowed difference between the 2 arm lengths
+// output: list of designs and the population of designed DNAzymes, in the same order
+func DesignDNAzymes(target string, template *Template, minArm,maxArm,skew int) ([]Design,Population) {
+    regions := template.regions
+    if len(regions) != 3 || regions[0].locked || !regions[1].locked || regions[2].locked {
+        panic("scanning needs a template of the form mutable,locked,mutable e.g. 10-23")
+    }
+    target = strings.ToUpper(target)
+    var designs []Design
+    var pop Population
+    for _,site := range FindCleavageSites(target,template.junction) {
+        for arm5 := minArm; arm5 <= maxArm; arm5++ {
+            for arm3 := minArm; arm3 <= maxArm; arm3++ {
+                if arm5-arm3 > skew || arm3-arm5 > skew {
+                    continue
+                }
+                if site-arm3 < 0 || site+1+arm5 > len(target) {//arms run off the target
+                    continue
+                }
+                d := Design{position:site,junction:target[site:site+2],arm5:arm5,arm3:arm3}
+                segments := d.Segments(target,template)
+                designs = append(designs,d)
+                pop = append(pop,Member{seq:template.Join(segments),
+                                        segments:segments,
+                                        header:d.Name(),
+                                        label:len(pop)})
+            }
+        }
+    }
+    return designs,pop
+}
+// WriteScanTSV() writes the designs ranked by fitness, best first
+// input: designs and their scored population, in the same order, output file name
+// output: no return, writes a tsv with one design per line
+func WriteScanTSV(designs []Design, pop Population, filename string) {
+    outfile,err := os.Create(filename)
+    if err != nil {
+        panic(err)
+    }
+    defer outfile.Close()
+    order := make([]int,len(pop))
+    for i := range order {
+        order[i] = i
+    }
+    sort.SliceStable(order,func(i,j int) bool { return pop[order[i]].fitness > pop[order[j]].fitness })
+    terms := pop.TermNames()
+    annotations := pop.AnnotationNames()
+    line := "Rank\tName\tPosition\tJunction\tArm3\tArm5\tFitness\tSequence"
+    for _,name := range append(terms,annotations...) {
+        line += "\t" + name
+    }
+    outfile.WriteString(line + "\n")
+    for rank,i := range order {
+        d, member := designs[i], pop[i]
+        line = fmt.Sprintf("%d\t%s\t%d\t%s\t%d\t%d\t%f\t%s",rank+1,d.Name(),d.position+1,d.junction,d.arm3,d.arm5,member.fitness,member.seq)
+        for _,term := range terms {
+            line += fmt.Sprintf("\t%f",member.scores[term])
+        }
+        for _,name := range annotations {
+            line += "\t" + member.FormatAnnotation(name)
+        }
+        outfile.WriteString(line + "\n")
+    }
+}
+// RunScan() designs and scores a DNAzyme for every cleavage junction of the target
+// input: target fasta, template, fitness terms and arm length settings, output tsv
+// output: no return, writes the ranked designs to outfile
+func RunScan(targetFile string, template *Template, ctx FitnessContext, fitness_spec string, minArm,maxArm,skew int, outfile string) {
+    ctx = PrepareContext(ctx,targetFile)
+    designs, pop := DesignDNAzymes(ctx.target_seq,template,minArm,maxArm,skew)
+    if len(pop) == 0 {
+        panic("No cleavage junctions with room for the binding arms found in the target")
+    }
+    pop.ScoreFitness(ParseFitnessFunction(fitness_spec,ctx))
+    WriteScanTSV(designs,pop,outfile)
+}
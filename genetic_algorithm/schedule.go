@@ -0,0 +1,123 @@
+package main
+
+import(
+    "math"
+    "errors"
+    "strings"
+)
+
+//Mutation rate schedules
+//the mutation rate is updated before breeding every generation, with -mutation-schedule
+//  constant     the rate set with -mutation for the whole run
+//  linear       decays linearly from -mutation to -mutation_final over -maxIters generations
+//  exponential  decays geometrically from -mutation to -mutation_final
+//  one-fifth    Rechenberg's 1/5th success rule, the rate grows if more than 1/5 of
+//               new members beat their parents and shrinks if fewer do
+//  diversity    rises from -mutation towards -mutation_max as the population entropy collapses
+
+var MUTATION_SCHEDULES = []string{"constant","linear","exponential","one-fifth","diversity"}
+const ONE_FIFTH_FACTOR = 0.85 //rate multiplied (or divided) by this each generation (Schwefel 1995)
+
+// MutationSchedule sets the mutation rate of every generation
+type MutationSchedule struct {
+    mode string //one of MUTATION_SCHEDULES
+    initial float64
+    final float64 //end rate of the decays, lower bound of one-fifth
+    max float64 //upper bound of one-fifth and diversity
+    generations int //length of the decays
+    rate float64 //current rate
+}
+
+// NewMutationSchedule() a schedule starting at the initial rate
+func NewMutationSchedule(mode string, initial,final,max float64, generations int) *MutationSchedule {
+    return &MutationSchedule{mode:mode,initial:initial,final:final,max:max,generations:generations,rate:initial}
+}
+// Check() whether the schedule's rates suit its mode, e.g. an exponential decay from 0 is NaN
+// output: nil, or an error saying which setting is invalid
+func (s *MutationSchedule) Check() error {
+    switch false {
+        case InList(s.mode,MUTATION_SCHEDULES):
+            return errors.New("mutation schedule must be one of {"+strings.Join(MUTATION_SCHEDULES,"|")+"}")
+        case !InList(s.mode,[]string{"linear","exponential","one-fifth"}) || (s.final > 0 && s.final <= 1):
+            return errors.New("final mutation rate must be in (0,1]")
+        case !InList(s.mode,[]string{"one-fifth","diversity"}) || Between(s.max,s.initial,1):
+            return errors.New("max mutation rate must be in [mutation rate,1]")
+        case s.mode != "exponential" || s.initial > 0:
+            return errors.New("exponential mutation schedule needs a mutation rate > 0")
+    }
+    return nil
+}
+// Update() mutation rate for breeding the next generation
+// input: generation number (from 0) and the current population
+// output: the new rate, also kept as the schedule's current rate
+func (s *MutationSchedule) Update(gen int, pop Population) float64 {
+    progress := float64(gen)/float64(Max(1,s.generations-1)) //fraction of the run done
+    switch s.mode {
+        case "constant":
+            s.rate = s.initial
+        case "linear":
+            s.rate = s.initial + (s.final-s.initial)*math.Min(1,progress)
+        case "exponential":
+            s.rate = s.initial*math.Pow(s.final/s.initial,math.Min(1,progress))
+        case "one-fifth":
+            success, bred := pop.SuccessRate()
+            if bred {
+                if success > 0.2 {
+                    s.rate /= ONE_FIFTH_FACTOR
+                } else if success < 0.2 {
+                    s.rate *= ONE_FIFTH_FACTOR
+                }
+                s.rate = math.Max(s.final,math.Min(s.max,s.rate))
+            }
+        case "diversity":
+            s.rate = s.initial + (s.max-s.initial)*(1-pop.Entropy())
+        default:
+            panic("Invalid mutation schedule, must be one of {constant|linear|exponential|one-fifth|diversity}")
+    }
+    return s.rate
+}
+
+// SuccessRate() fraction of bred members fitter than their fittest parent
+// output: success rate and whether the population has any bred members
+func (pop Population) SuccessRate() (float64, bool) {
+    bred, success := 0, 0
+    for _,member := range pop {
+        if member.bred {
+            bred++
+            if member.fitness > member.parent_fitness {
+                success++
+            }
+        }
+    }
+    return float64(success)/float64(Max(1,bred)), bred > 0
+}
+// Entropy() mean Shannon entropy of the bases at each position, normalised to [0,1]
+// positions are counted from the 5' end up to the length at least half the members reach
+// 1 for a random population and 0 when every member is the same
+func (pop Population) Entropy() float64 {
+    var entropies []float64
+    for p := 0; ; p++ {
+        var counts [256]float64 //by base, summed in a fixed order so the entropy is reproducible
+        n := 0.0
+        for _,member := range pop {
+            if p < len(member.seq) {
+                counts[member.seq[p]]++
+                n++
+            }
+        }
+        if n == 0 || n < float64(len(pop))/2 {
+            break
+        }
+        h := 0.0
+        for _,count := range counts {
+            if count > 0 {
+                h -= count/n*math.Log2(count/n)
+            }
+        }
+        entropies = append(entropies,h/2) //at most 2 bits per base
+    }
+    if len(entropies) == 0 {
+        return 0
+    }
+    return Mean(entropies)
+}
@@ -0,0 +1,97 @@
+package main
+
+import(
+    "fmt"
+    "math"
+    "strings"
+    "math/rand"
+)
+
+//Seeding the initial population
+//with -seed-pop the initial population starts from known sequences (e.g. published DNAzymes
+//or hits from an earlier run) instead of only random ones
+//  a -seed_fraction of the members are seeded, the rest are random as before
+//  seeded members are the seeds themselves, picked at random, and if there are fewer seeds
+//  than seeded members the rest are variants of random seeds mutated at -seed_mutation
+
+// Seeding holds the sequences and settings used to seed the initial population
+type Seeding struct {
+    seeds Population //nil for a random initial population
+    fraction float64 //fraction of the initial population that is seeded
+    mutation float64 //mutation rate of the variants filling up the seeded members
+}
+
+// ReadSeeds() reads the seed sequences from a fasta file
+// bases other than A,C,G,T (e.g. N) are replaced by random bases and empty entries are dropped,
+// with a template only seeds that fit it are kept, split into its regions
+// input: fasta file name and the template (nil for none)
+// output: seed population, panics if no seed is usable
+func ReadSeeds(filename string, template *Template) Population {
+    var seeds Population
+    unfit := 0
+    for _,seed := range FastaToPopulation(filename) {
+        seed.seq = ResolveBases(seed.seq)
+        if len(seed.seq) == 0 {
+            continue
+        }
+        if template != nil {
+            segments, ok := template.Segment(seed.seq)
+            if !ok {
+                unfit++
+                continue
+            }
+            seed.segments = segments
+        }
+        seeds = append(seeds,seed)
+    }
+    if unfit > 0 {
+        fmt.Printf("%d seeds in %s do not fit template %s and were skipped\n",unfit,filename,template.name)
+    }
+    if len(seeds) == 0 {
+        panic("No usable seed sequences in " + filename)
+    }
+    return seeds
+}
+// ResolveBases() replaces every base other than A,C,G,T with a random base
+func ResolveBases(seq string) string {
+    resolved := []byte(strings.ToUpper(seq))
+    for i,base := range resolved {
+        if _, ok := BASE_CODES[base]; !ok {
+            resolved[i] = PickRandomBase(RNG)[0]
+        }
+    }
+    return string(resolved)
+}
+
+// Members() the seeded members of an initial population
+// input: population size and the breeding settings used to mutate variants
+// output: round(fraction*size) members, seeds first then variants, empty without seeds
+func (seeding Seeding) Members(size int, breeder Breeder) Population {
+    n := Min(size,int(math.Round(seeding.fraction*float64(size))))
+    if len(seeding.seeds) == 0 || n == 0 {
+        return Population{}
+    }
+    members := make(Population,0,n)
+    for _,i := range RNG.Perm(len(seeding.seeds))[:Min(n,len(seeding.seeds))] {
+        members = append(members,seeding.seeds[i])
+    }
+    for len(members) < n {
+        seed := seeding.seeds[RNG.Intn(len(seeding.seeds))]
+        members = append(members,seed.Variant(RNG,breeder,seeding.mutation))
+    }
+    return members
+}
+// Variant() mutated copy of a seed, named after it, with mutations drawn from rng
+func (seed Member) Variant(rng *rand.Rand, breeder Breeder, mutation_rate float64) Member {
+    var variant Member
+    if len(seed.header) != 0 {
+        variant.header = seed.header + "_variant"
+    }
+    if breeder.template != nil {
+        variant.segments = breeder.template.Mutate(rng,seed.segments,breeder.mutation_model,mutation_rate,breeder.indel_rate)
+        variant.seq = breeder.template.Join(variant.segments)
+    } else {
+        variant.seq = breeder.mutation_model.Mutate(rng,seed.seq,mutation_rate,breeder.indel_rate)
+    }
+    return variant
+}
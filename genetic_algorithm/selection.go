@@ -0,0 +1,189 @@
+package main
+
+import(
+    "math"
+    "sort"
+    "math/rand"
+)
+
+//Parent selection
+//every generation the elites are copied to the next generation unchanged and a
+//Selector picks the parents of each new member, with
+//  truncation  parents drawn uniformly from the elites (the fittest members)
+//  nsga2       elites are the best members by Pareto front, parents drawn uniformly from them
+//  tournament  each parent is the fittest of tournament_size random members
+//  roulette    parents drawn with probability proportional to fitness
+//  rank        parents drawn with linearly decreasing probability by fitness rank
+//  sus         stochastic universal sampling, fitness proportional with evenly spaced pointers
+
+var SELECTIONS = []string{"truncation","tournament","roulette","rank","sus","nsga2"}
+
+// Selector picks the members that survive and breed each generation
+type Selector interface {
+    Elites(generation Population, top_sequence_percent float64) Population //copied unchanged
+    Parents(rng *rand.Rand, generation Population, elites Population, n int) Population //n parents drawn from rng, paired in order
+}
+
+// NewSelector() the selector for a selection mode
+// input: breeding settings and the fitness function (nsga2 selects on its objectives)
+// output: Selector, panics for an unknown mode
+func NewSelector(breeder Breeder, fitness FitnessFunction) Selector {
+    switch breeder.selection {
+        case "truncation":
+            return TruncationSelector{}
+        case "nsga2":
+            return ParetoSelector{objectives:fitness.Objectives()}
+        case "tournament":
+            return TournamentSelector{size:breeder.tournament_size}
+        case "roulette":
+            return RouletteSelector{}
+        case "rank":
+            return RankSelector{pressure:breeder.selection_pressure}
+        case "sus":
+            return SUSSelector{}
+        default:
+            panic("Invalid selection mode, must be one of {truncation|tournament|roulette|rank|sus|nsga2}")
+    }
+}
+
+// FitnessElites keeps the fittest members as elites, embedded by most selectors
+type FitnessElites struct {}
+func (FitnessElites) Elites(generation Population, top_sequence_percent float64) Population {
+    return GetFittestMembers(generation,top_sequence_percent)
+}
+// PickUniform() n members drawn uniformly with replacement
+func PickUniform(rng *rand.Rand, pool Population, n int) Population {
+    picked := make(Population,n)
+    for i := range picked {
+        picked[i] = pool[rng.Intn(len(pool))]
+    }
+    return picked
+}
+
+// TruncationSelector breeds only from the elites
+type TruncationSelector struct {
+    FitnessElites
+}
+func (s TruncationSelector) Parents(rng *rand.Rand, generation Population, elites Population, n int) Population {
+    return PickUniform(rng,elites,n)
+}
+// ParetoSelector keeps and breeds from the best members by NSGA-II, see pareto.go
+type ParetoSelector struct {
+    objectives []string
+}
+func (s ParetoSelector) Elites(generation Population, top_sequence_percent float64) Population {
+    return GetParetoFittestMembers(generation,s.objectives,top_sequence_percent)
+}
+func (s ParetoSelector) Parents(rng *rand.Rand, generation Population, elites Population, n int) Population {
+    return PickUniform(rng,elites,n)
+}
+// TournamentSelector each parent wins a tournament of size random members
+// larger tournaments give more selection pressure
+type TournamentSelector struct {
+    FitnessElites
+    size int
+}
+func (s TournamentSelector) Parents(rng *rand.Rand, generation Population, elites Population, n int) Population {
+    parents := make(Population,n)
+    for i := range parents {
+        best := generation[rng.Intn(len(generation))]
+        for j := 1; j < s.size; j++ {
+            if contender := generation[rng.Intn(len(generation))]; contender.fitness > best.fitness {
+                best = contender
+            }
+        }
+        parents[i] = best
+    }
+    return parents
+}
+// RouletteSelector draws parents with probability proportional to fitness
+type RouletteSelector struct {
+    FitnessElites
+}
+func (s RouletteSelector) Parents(rng *rand.Rand, generation Population, elites Population, n int) Population {
+    return PickWeighted(rng,generation,FitnessWeights(generation),n)
+}
+// RankSelector draws parents by linear ranking, the fittest member has pressure
+// times the average probability and the least fit 2-pressure times, pressure in [1,2]
+type RankSelector struct {
+    FitnessElites
+    pressure float64
+}
+func (s RankSelector) Parents(rng *rand.Rand, generation Population, elites Population, n int) Population {
+    order := FitnessOrder(generation)
+    weights := make([]float64,len(generation))
+    for rank,i := range order {//rank 0 is the least fit
+        weights[i] = 2 - s.pressure
+        if len(order) > 1 {
+            weights[i] += 2*(s.pressure-1)*float64(rank)/float64(len(order)-1)
+        }
+    }
+    return PickWeighted(rng,generation,weights,n)
+}
+// SUSSelector stochastic universal sampling (Baker 1987), n evenly spaced pointers with
+// one random offset, so each member is picked close to its expected number of times
+type SUSSelector struct {
+    FitnessElites
+}
+func (s SUSSelector) Parents(rng *rand.Rand, generation Population, elites Population, n int) Population {
+    weights := FitnessWeights(generation)
+    total := Sum(weights)
+    parents := make(Population,0,n)
+    step := total/float64(n)
+    pointer := rng.Float64()*step
+    cumulative := 0.0
+    for i,weight := range weights {
+        cumulative += weight
+        for len(parents) < n && pointer < cumulative {
+            parents = append(parents,generation[i])
+            pointer += step
+        }
+    }
+    for len(parents) < n {//rounding at the end
+        parents = append(parents,generation[len(generation)-1])
+    }
+    //pointers pick neighbours in order, shuffle so parents are paired at random
+    rng.Shuffle(len(parents),func(i,j int) { parents[i], parents[j] = parents[j], parents[i] })
+    return parents
+}
+
+// FitnessWeights() selection weights proportional to fitness
+// fitness is shifted to start at 0 if any is negative, all equal weights if every fitness is 0
+func FitnessWeights(pop Population) []float64 {
+    weights := pop.FitnessList()
+    lowest := math.Min(0,MinFloat(weights))
+    for i := range weights {
+        weights[i] -= lowest
+    }
+    if Sum(weights) == 0 {
+        for i := range weights {
+            weights[i] = 1
+        }
+    }
+    return weights
+}
+// PickWeighted() n members drawn with replacement with probability proportional to weight
+func PickWeighted(rng *rand.Rand, pop Population, weights []float64, n int) Population {
+    cumulative := make([]float64,len(weights))
+    total := 0.0
+    for i,weight := range weights {
+        total += weight
+        cumulative[i] = total
+    }
+    picked := make(Population,n)
+    for i := range picked {
+        r := rng.Float64()*total
+        j := sort.Search(len(cumulative),func(j int) bool { return cumulative[j] > r })
+        picked[i] = pop[Min(j,len(pop)-1)]
+    }
+    return picked
+}
+// FitnessOrder() indices of the members from least to most fit
+func FitnessOrder(pop Population) []int {
+    order := make([]int,len(pop))
+    for i := range order {
+        order[i] = i
+    }
+    sort.SliceStable(order,func(i,j int) bool { return pop[order[i]].fitness < pop[order[j]].fitness })
+    return order
+}
@@ -0,0 +1,294 @@
+package main
+
+import(
+    "os"
+    "fmt"
+    "math"
+    "sort"
+)
+
+//Simulated SELEX
+//-mode selex replaces the GA loop with rounds of in vitro selection on a library of species,
+//each a Member standing for copies identical molecules, every round
+//  binding       each molecule is retained with probability carryover + (1-carryover)*a^stringency
+//                where the affinity a is the fitness (the fitness terms are the affinity model) in [0,1],
+//                carryover is nonspecific background binding
+//  amplification the retained molecules are amplified by pcr_cycles of PCR, each cycle copies a
+//                molecule with probability (its efficiency), lower the further its GC content is from 0.5,
+//                copies carry errors at pcr_error per base with the mutation model (error-prone PCR)
+//  subsampling   pool molecules are drawn from the amplified library for the next round
+//the copies of every species are tracked across rounds and the top species of every round
+//are written with their enrichment to an enrichment table
+
+var MODES = []string{"ga","selex"}
+
+// SELEX holds the simulated SELEX settings
+type SELEX struct {
+    rounds int
+    pool int //molecules kept after each round
+    stringency float64 //exponent on the affinity, higher retains weak binders less
+    carryover float64 //probability that any molecule is retained without binding
+    pcr_cycles int
+    pcr_efficiency float64 //fraction of molecules copied each cycle at GC content 0.5
+    pcr_bias float64 //efficiency lost at GC content 0 or 1
+    pcr_error float64 //errors per base per copy
+    top int //species per round in the enrichment table
+}
+// SELEXRound is the library after one round
+type SELEXRound struct {
+    round int
+    library Population //species, copies are molecules
+    molecules int
+    retained int //molecules retained by binding
+}
+
+// Retention() probability that a molecule of the species is retained by binding the target
+func (selex SELEX) Retention(species Member) float64 {
+    affinity := math.Max(0,math.Min(1,species.fitness))
+    return selex.carryover + (1-selex.carryover)*math.Pow(affinity,selex.stringency)
+}
+// Efficiency() PCR efficiency of the species, biased against extreme GC content
+func (selex SELEX) Efficiency(species Member) float64 {
+    return selex.pcr_efficiency*(1 - selex.pcr_bias*math.Abs(2*species.GCContent()-1))
+}
+
+// InitialLibrary() the starting library, the initial population with pool/size copies of each member
+func (selex SELEX) InitialLibrary(population Population) Population {
+    library := population.Collapse()
+    for i := range library {
+        library[i].copies *= Max(1,selex.pool/len(population))
+    }
+    return library
+}
+// Bind() molecules of every species retained by binding the target
+// input: scored library
+// output: library of the retained species with their retained copies
+func (selex SELEX) Bind(library Population) Population {
+    var retained Population
+    for _,species := range library {
+        if copies := Binomial(species.copies,selex.Retention(species)); copies > 0 {
+            species.copies = copies
+            retained = append(retained,species)
+        }
+    }
+    return retained
+}
+// Amplify() amplifies the retained library by PCR and draws pool molecules from it
+// the amplified copies are expected values, molecules are then drawn from them and every
+// molecule drawn is a mutant with the fraction of mutant copies PCR made of its species
+// input: retained library and breeding settings for the mutation model and indel rate
+// output: the next library, mutants are new species that need scoring
+func (selex SELEX) Amplify(retained Population, breeder Breeder) Population {
+    amplified := make([]float64,len(retained))
+    mutant := make([]float64,len(retained)) //fraction of the amplified copies with errors
+    for i,species := range retained {
+        efficiency := selex.Efficiency(species)
+        errorFree := math.Pow(1-selex.pcr_error,float64(len(species.seq))) //copy without errors
+        amplified[i] = float64(species.copies)*math.Pow(1+efficiency,float64(selex.pcr_cycles))
+        mutant[i] = 1 - math.Pow((1+efficiency*errorFree)/(1+efficiency),float64(selex.pcr_cycles))
+    }
+    drawn := Subsample(amplified,Min(selex.pool,int(math.Round(Sum(amplified)))))
+    index := make(map[string]int,len(retained)) //position of every species in the next library
+    var library Population
+    add := func(species Member, copies int) {
+        if i, ok := index[species.seq]; ok {
+            library[i].copies += copies
+            return
+        }
+        index[species.seq] = len(library)
+        species.copies = copies
+        library = append(library,species)
+    }
+    for i,species := range retained {
+        mutants := Binomial(drawn[i],mutant[i])
+        if drawn[i]-mutants > 0 {
+            add(species,drawn[i]-mutants)
+        }
+        for m := 0; m < mutants; m++ {
+            add(species.PCRError(breeder,selex.pcr_error),1)
+        }
+    }
+    return library
+}
+// PCRError() copy of the species with at least one replication error, unscored
+// falls back to an unchanged copy if the model makes no change in MAX_CLONE_RETRIES tries
+func (species Member) PCRError(breeder Breeder, error_rate float64) Member {
+    for tries := 0; tries < MAX_CLONE_RETRIES; tries++ {
+        if mutant := species.Variant(RNG,breeder,error_rate); mutant.seq != species.seq {
+            mutant.header = ""
+            return mutant
+        }
+    }
+    return species
+}
+
+// ScoreUnscored() scores the species of the library that have no scores yet (new mutants)
+func (library Population) ScoreUnscored(fitness FitnessFunction) {
+    var unscored Population
+    var positions []int
+    for i,species := range library {
+        if species.scores == nil {
+            unscored = append(unscored,species)
+            positions = append(positions,i)
+        }
+    }
+    if len(unscored) == 0 {
+        return
+    }
+    unscored.ScoreFitness(fitness)
+    for j,i := range positions {
+        copies := library[i].copies
+        library[i] = unscored[j]
+        library[i].copies = copies
+    }
+}
+// Molecules() total copies of every species in the library
+func (library Population) Molecules() int {
+    total := 0
+    for _,species := range library {
+        total += species.copies
+    }
+    return total
+}
+
+// RunSELEX() simulates rounds of SELEX and returns the final library
+// input: initial population settings as in RunSimulation, the SELEX settings and the enrichment table file
+// output: species of the last library, copies are molecules, the rounds run and why it stopped
+func RunSELEX(lower int,
+              upper int,
+              size int,
+              targetFile string,
+              ctx FitnessContext,
+              fitness_spec string,
+              breeder Breeder,
+              seeding Seeding,
+              composition Composition,
+              selex SELEX,
+              tablefile string) (Population, int, string) {
+    HandleInterrupts()
+    ctx = PrepareContext(ctx,targetFile)
+    fitness := ParseFitnessFunction(fitness_spec,ctx)
+    FITNESS_CACHE.Open(FitnessSignature(fitness_spec,ctx))
+    library := selex.InitialLibrary(InitializeGeneration(size,lower,upper,breeder,seeding,composition,fitness))
+    rounds := []SELEXRound{SELEXRound{round:0,library:library,molecules:library.Molecules()}}
+    reason := fmt.Sprintf("rounds (%d)",selex.rounds)
+    fmt.Println("Round\tSpecies\tMolecules\tRetained\tMeanFitness\tTopFrequency")
+    rounds[0].Log()
+    for round := 1; round <= selex.rounds; round++ {
+        if Interrupted() {
+            reason = fmt.Sprintf("interrupted before round %d",round)
+            break
+        }
+        retained := selex.Bind(library)
+        if len(retained) == 0 {
+            reason = fmt.Sprintf("no molecules retained in round %d",round)
+            fmt.Println("No molecules retained in round ",round)
+            break
+        }
+        library = selex.Amplify(retained,breeder)
+        library.ScoreUnscored(fitness)
+        rounds = append(rounds,SELEXRound{round:round,library:library,molecules:library.Molecules(),retained:retained.Molecules()})
+        rounds[len(rounds)-1].Log()
+    }
+    WriteEnrichmentTable(rounds,selex,tablefile)
+    fmt.Println("Enrichment table written to ",tablefile)
+    sort.SliceStable(library,func(i,j int) bool { return library[i].copies > library[j].copies })
+    for i := range library {
+        library[i].label = i
+    }
+    return library, len(rounds)-1, reason
+}
+// Log() prints the summary line of a round
+func (r SELEXRound) Log() {
+    weighted, top := 0.0, 0
+    for _,species := range r.library {
+        weighted += species.fitness*float64(species.copies)
+        top = Max(top,species.copies)
+    }
+    retained := "-"
+    if r.round > 0 {
+        retained = fmt.Sprint(r.retained)
+    }
+    fmt.Printf("%d\t%d\t%d\t%s\t%f\t%f\n",r.round,len(r.library),r.molecules,retained,
+               weighted/float64(Max(1,r.molecules)),float64(top)/float64(Max(1,r.molecules)))
+}
+// WriteEnrichmentTable() writes the top species of every round with their enrichment
+// enrichment is the frequency of the species over its frequency in the previous round, NA if it was absent
+// input: every round, the SELEX settings and the tsv file name
+func WriteEnrichmentTable(rounds []SELEXRound, selex SELEX, filename string) {
+    outfile, err := os.Create(filename)
+    if err != nil { panic(err) }
+    defer outfile.Close()
+    outfile.WriteString("Round\tRank\tSequence\tCopies\tFrequency\tEnrichment\tFitness\tRetention\n")
+    previous := make(map[string]float64)
+    for _,r := range rounds {
+        frequencies := make(map[string]float64,len(r.library))
+        for _,species := range r.library {
+            frequencies[species.seq] = float64(species.copies)/float64(Max(1,r.molecules))
+        }
+        ranked := make(Population,len(r.library))
+        copy(ranked,r.library)
+        sort.SliceStable(ranked,func(i,j int) bool { return ranked[i].copies > ranked[j].copies })
+        for rank,species := range ranked[:Min(selex.top,len(ranked))] {
+            enrichment := "NA"
+            if before, ok := previous[species.seq]; ok && r.round > 0 {
+                enrichment = fmt.Sprintf("%f",frequencies[species.seq]/before)
+            }
+            outfile.WriteString(fmt.Sprintf("%d\t%d\t%s\t%d\t%g\t%s\t%f\t%f\n",
+                                            r.round,rank+1,species.seq,species.copies,frequencies[species.seq],
+                                            enrichment,species.fitness,selex.Retention(species)))
+        }
+        previous = frequencies
+    }
+}
+
+// Binomial() random number of successes in n trials with probability p
+// exact for small n, Poisson or normal approximations otherwise
+func Binomial(n int, p float64) int {
+    switch {
+        case n <= 0 || p <= 0:
+            return 0
+        case p >= 1:
+            return n
+        case n < 100:
+            k := 0
+            for i := 0; i < n; i++ {
+                if RNG.Float64() < p {
+                    k++
+                }
+            }
+            return k
+        case float64(n)*p < 20:
+            return Min(n,Poisson(float64(n)*p))
+        case float64(n)*(1-p) < 20:
+            return n - Min(n,Poisson(float64(n)*(1-p)))
+        default:
+            k := int(math.Round(float64(n)*p + RNG.NormFloat64()*math.Sqrt(float64(n)*p*(1-p))))
+            return Max(0,Min(n,k))
+    }
+}
+// Poisson() random count with the given mean (Knuth's method, for small means)
+func Poisson(mean float64) int {
+    limit := math.Exp(-mean)
+    k, product := 0, RNG.Float64()
+    for product > limit {
+        k++
+        product *= RNG.Float64()
+    }
+    return k
+}
+// Subsample() draws n items from categories with probability proportional to their weights (multinomial)
+// output: number drawn from each category
+func Subsample(weights []float64, n int) []int {
+    drawn := make([]int,len(weights))
+    remaining, total := n, Sum(weights)
+    for i,weight := range weights {
+        if remaining == 0 || total <= 0 {
+            break
+        }
+        drawn[i] = Binomial(remaining,math.Min(1,weight/total))
+        remaining -= drawn[i]
+        total -= weight
+    }
+    return drawn
+}
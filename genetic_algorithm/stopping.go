@@ -0,0 +1,243 @@
+package main
+
+import(
+    "os"
+    "fmt"
+    "math"
+    "time"
+    "strings"
+    "strconv"
+    "syscall"
+    "os/signal"
+    "sync/atomic"
+    "encoding/json"
+)
+
+//Stop conditions
+//-stop combines conditions with AND and OR (AND binds tighter), e.g.
+//  -stop "plateau OR patience:best:20:0.0001 OR target:0.9 AND evals:50000 OR time:2h"
+//the conditions are
+//  plateau                          the -plateau fitness CoV check over -plateau_gens generations
+//  patience:{best|mean}:N[:EPS]     best or mean fitness has not improved by more than EPS (default 0) in N generations
+//  target:F                         best fitness reached F
+//  evals:N                          N fitness evaluations (sequences scored, cache hits are free)
+//  time:D                           the run has taken D, a Go duration e.g. 90s, 30m or 2h
+//-maxIters always stops the run
+//the first SIGINT (Ctrl-C) or SIGTERM stops every island after its current generation and the
+//results are written as usual but marked interrupted, a second one exits at once
+
+var STOP_CONDITIONS = []string{"plateau","patience","target","evals","time"}
+var PLATEAU_MODES = []string{"cov_mean","cov"}
+var FITNESS_EVALUATIONS int64 //sequences scored so far, updated atomically
+var INTERRUPTED int32 //set to 1 by the first SIGINT or SIGTERM, updated atomically
+
+// StopCondition is one condition of the -stop rule
+type StopCondition struct {
+    kind string //one of STOP_CONDITIONS
+    stat string //best or mean, for patience
+    generations int //for patience
+    epsilon float64 //for patience
+    target float64
+    evaluations int64
+    duration time.Duration
+}
+// Stopper decides when an island stops, the rule is an OR of ANDs of conditions
+type Stopper struct {
+    rule [][]StopCondition
+    plateau_mode string //one of PLATEAU_MODES
+    plateau_tolerance float64
+    plateau_gens int
+    start time.Time //start of the run, set when it starts
+}
+// StopState is what an island remembers for its stop conditions
+type StopState struct {
+    history [][]float64 //fitness values of the last generations, for the plateau check
+    best []float64 //best fitness so far at every generation
+    mean []float64 //best mean fitness so far at every generation
+}
+// RunInfo is the metadata of a run written next to the results
+type RunInfo struct {
+    Mode string `json:"mode"`
+    StopRule string `json:"stop_rule,omitempty"`
+    StopReason string `json:"stop_reason"`
+    Generations int `json:"generations"`
+    Evaluations int64 `json:"evaluations"`
+    Seconds float64 `json:"seconds"`
+    Interrupted bool `json:"interrupted"`
+    Islands []string `json:"island_stop_reasons,omitempty"`
+}
+
+// ParseStopRule() parses the -stop rule
+// input: rule as conditions joined by AND and OR ("" to stop only at maxIters) and the plateau settings
+// output: Stopper, panics if the rule is invalid
+func ParseStopRule(spec string, plateau_mode string, plateau_tolerance float64, plateau_gens int) Stopper {
+    if !InList(plateau_mode,PLATEAU_MODES) {
+        panic("Invalid plateau mode, must be {"+strings.Join(PLATEAU_MODES,"|")+"}")
+    }
+    stopper := Stopper{plateau_mode:plateau_mode,
+                       plateau_tolerance:plateau_tolerance,
+                       plateau_gens:plateau_gens,
+                      }
+    var all []StopCondition //conditions joined by AND
+    expectCondition := true
+    for _,token := range strings.Fields(spec) {
+        switch strings.ToUpper(token) {
+            case "OR","AND":
+                if expectCondition {
+                    panic("Invalid stop rule " + spec + ", AND and OR must be between conditions")
+                }
+                if strings.ToUpper(token) == "OR" {
+                    stopper.rule = append(stopper.rule,all)
+                    all = nil
+                }
+                expectCondition = true
+            default:
+                if !expectCondition {
+                    panic("Invalid stop rule " + spec + ", conditions must be joined by AND or OR")
+                }
+                all = append(all,ParseStopCondition(token))
+                expectCondition = false
+        }
+    }
+    if expectCondition && len(strings.Fields(spec)) > 0 {
+        panic("Invalid stop rule " + spec + ", it cannot end with AND or OR")
+    }
+    if len(all) > 0 {
+        stopper.rule = append(stopper.rule,all)
+    }
+    return stopper
+}
+// ParseStopCondition() parses one condition of the -stop rule, see the top of this file
+func ParseStopCondition(token string) StopCondition {
+    fields := strings.Split(token,":")
+    condition := StopCondition{kind:strings.ToLower(fields[0])}
+    invalid := fmt.Sprintf("Invalid stop condition %q, must be one of plateau, patience:{best|mean}:N[:EPS], target:F, evals:N or time:D",token)
+    var err error
+    switch {
+        case condition.kind == "plateau" && len(fields) == 1:
+        case condition.kind == "patience" && (len(fields) == 3 || len(fields) == 4) && InList(fields[1],[]string{"best","mean"}):
+            condition.stat = fields[1]
+            condition.generations, err = strconv.Atoi(fields[2])
+            if err != nil || condition.generations < 1 {
+                panic(invalid)
+            }
+            if len(fields) == 4 {
+                condition.epsilon, err = strconv.ParseFloat(fields[3],64)
+                if err != nil || condition.epsilon < 0 {
+                    panic(invalid)
+                }
+            }
+        case condition.kind == "target" && len(fields) == 2:
+            condition.target, err = strconv.ParseFloat(fields[1],64)
+            if err != nil {
+                panic(invalid)
+            }
+        case condition.kind == "evals" && len(fields) == 2:
+            condition.evaluations, err = strconv.ParseInt(fields[1],10,64)
+            if err != nil || condition.evaluations < 1 {
+                panic(invalid)
+            }
+        case condition.kind == "time" && len(fields) == 2:
+            condition.duration, err = time.ParseDuration(fields[1])
+            if err != nil || condition.duration <= 0 {
+                panic(invalid)
+            }
+        default:
+            panic(invalid)
+    }
+    return condition
+}
+
+// Observe() records the fitness of the island's current generation
+func (state *StopState) Observe(pop Population, plateau_gens int) {
+    fitnesses := pop.FitnessList()
+    //keep only last plateau_gens generational fitnesses stored
+    state.history = state.history[Max(0,len(state.history)-plateau_gens):len(state.history)]
+    state.history = append(state.history,fitnesses)
+    best, mean := MaxFloat(fitnesses), Mean(fitnesses)
+    if n := len(state.best); n > 0 {
+        best, mean = math.Max(best,state.best[n-1]), math.Max(mean,state.mean[n-1])
+    }
+    state.best = append(state.best,best)
+    state.mean = append(state.mean,mean)
+}
+// Stop() checks the rule against the island's state after Observe
+// output: whether to stop and why, the conditions that were met
+func (stopper Stopper) Stop(state *StopState) (bool, string) {
+    for _,all := range stopper.rule {
+        var reasons []string
+        for _,condition := range all {
+            met, reason := stopper.Met(condition,state)
+            if !met {
+                break
+            }
+            reasons = append(reasons,reason)
+        }
+        if len(reasons) == len(all) {
+            return true, strings.Join(reasons," AND ")
+        }
+    }
+    return false, ""
+}
+// Met() checks one condition against the island's state
+// output: whether it is met and the reason to report if it is
+func (stopper Stopper) Met(condition StopCondition, state *StopState) (bool, string) {
+    switch condition.kind {
+        case "plateau":
+            return FitnessPlateau(stopper.plateau_mode,state.history,stopper.plateau_tolerance),
+                   fmt.Sprintf("plateau (%s < %g over %d generations)",stopper.plateau_mode,stopper.plateau_tolerance,stopper.plateau_gens)
+        case "patience"://improvement of the best so far over the last N generations
+            sofar := state.best
+            if condition.stat == "mean" {
+                sofar = state.mean
+            }
+            n := len(sofar)
+            if n <= condition.generations {
+                return false, ""
+            }
+            improvement := sofar[n-1] - sofar[n-1-condition.generations]
+            return improvement <= condition.epsilon,
+                   fmt.Sprintf("patience (%s fitness improved by %g <= %g in %d generations)",condition.stat,improvement,condition.epsilon,condition.generations)
+        case "target":
+            best := state.best[len(state.best)-1]
+            return best >= condition.target, fmt.Sprintf("target (best fitness %g >= %g)",best,condition.target)
+        case "evals":
+            evaluations := atomic.LoadInt64(&FITNESS_EVALUATIONS)
+            return evaluations >= condition.evaluations, fmt.Sprintf("evals (%d >= %d)",evaluations,condition.evaluations)
+        case "time":
+            elapsed := time.Since(stopper.start)
+            return elapsed >= condition.duration, fmt.Sprintf("time (%s >= %s)",elapsed.Round(time.Second),condition.duration)
+        default:
+            panic("Invalid stop condition " + condition.kind)
+    }
+}
+
+// HandleInterrupts() stops the run after the current generation on the first SIGINT or SIGTERM
+// and exits on the second
+func HandleInterrupts() {
+    signals := make(chan os.Signal,2)
+    signal.Notify(signals,os.Interrupt,syscall.SIGTERM)
+    go func() {
+        <-signals
+        atomic.StoreInt32(&INTERRUPTED,1)
+        fmt.Println("\nInterrupted, stopping after the current generation, interrupt again to exit without writing results")
+        <-signals
+        fmt.Println("\nInterrupted again, exiting")
+        KillPythonWorkers() //they are in their own process groups so did not get the signal
+        os.Exit(130)
+    }()
+}
+// Interrupted() whether the run has been interrupted
+func Interrupted() bool {
+    return atomic.LoadInt32(&INTERRUPTED) == 1
+}
+
+// Write() writes the run metadata as json
+func (info RunInfo) Write(filename string) {
+    outfile, err := os.Create(filename)
+    if err != nil { panic(err) }
+    defer outfile.Close()
+    line, err := json.MarshalIndent(info,"","    ")
+    if err != nil { panic(err) }
+    outfile.Write(append(line,'\n'))
+}
@@ -0,0 +1,76 @@
+package main
+
+import(
+    "fmt"
+    "flag"
+    "sync"
+    "time"
+    "strings"
+    "net/http"
+    "encoding/json"
+)
+
+//Stub model server
+//a tiny stand in for a classifier served over HTTP, for testing -model-url
+//POST {"seqs": [...]} to /predict and get {"predictions": [...]} back
+//the prediction of a sequence is its GC content, failures can be injected with flags
+
+// Request is the body posted by the genetic algorithm
+type Request struct {
+    Seqs []string `json:"seqs"`
+}
+// Response is the body sent back
+type Response struct {
+    Predictions []float64 `json:"predictions"`
+}
+
+// GCContent() fraction of bases in the sequence that are G or C
+func GCContent(seq string) float64 {
+    if len(seq) == 0 {
+        return 0
+    }
+    seq = strings.ToUpper(seq)
+    return float64(strings.Count(seq,"G")+strings.Count(seq,"C"))/float64(len(seq))
+}
+
+func main() {
+    addr := flag.String("addr","127.0.0.1:8080","address to listen on")
+    fail := flag.Int("fail",0,"answer the first N requests with 503, to test retries")
+    delay := flag.Int("delay",0,"milliseconds to wait before answering each request")
+    short := flag.Bool("short",false,"drop the last prediction of every response, to test validation")
+    flag.Parse()
+
+    var lock sync.Mutex
+    requests := 0
+    http.HandleFunc("/predict",func(w http.ResponseWriter, r *http.Request) {
+        lock.Lock()
+        requests++
+        n := requests
+        lock.Unlock()
+        if r.Method != http.MethodPost {
+            http.Error(w,"POST only",http.StatusMethodNotAllowed)
+            return
+        }
+        time.Sleep(time.Duration(*delay)*time.Millisecond)
+        if n <= *fail {
+            http.Error(w,"injected failure",http.StatusServiceUnavailable)
+            return
+        }
+        var request Request
+        if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+            http.Error(w,err.Error(),http.StatusBadRequest)
+            return
+        }
+        var response Response
+        for _,seq := range request.Seqs {
+            response.Predictions = append(response.Predictions,GCContent(seq))
+        }
+        if *short && len(response.Predictions) > 0 {
+            response.Predictions = response.Predictions[:len(response.Predictions)-1]
+        }
+        w.Header().Set("Content-Type","application/json")
+        json.NewEncoder(w).Encode(response)
+    })
+    fmt.Println("Stub model server listening on",*addr)
+    if err := http.ListenAndServe(*addr,nil); err != nil { panic(err) }
+}
@@ -0,0 +1,261 @@
+package main
+
+import(
+    "os"
+    "fmt"
+    "bufio"
+    "strings"
+    "math/rand"
+)
+
+//Genome templates
+//a template fixes the layout of every member as a list of regions, e.g. the
+//binding arms and catalytic core of a 10-23 DNAzyme, so breeding never destroys the core
+//locked regions never change, mutable regions only get substitutions
+//and length-variable regions also get indels within their length bounds
+
+// Region is one part of a template
+type Region struct {
+    name string
+    locked bool
+    seq string //sequence of a locked region
+    min int //length bounds of a mutable region, min == max for a fixed length
+    max int
+}
+// Template is the ordered list of regions making up a member
+type Template struct {
+    name string
+    regions []Region
+    junction string //IUPAC motif of the target dinucleotide cleaved, e.g. RY
+}
+
+// BUILTIN_TEMPLATES are the templates available by name with -template
+// both have binding arms of 7-13 bases around the catalytic core
+// 10-23 cleaves purine-pyrimidine junctions and 8-17 AG junctions
+var BUILTIN_TEMPLATES = map[string]Template{
+    "10-23": Template{name:"10-23",
+                      junction:"RY",
+                      regions:[]Region{
+                          Region{name:"arm5",min:7,max:13},
+                          Region{name:"core",locked:true,seq:"GGCTAGCTACAACGA"},
+                          Region{name:"arm3",min:7,max:13},
+                      }},
+    "8-17": Template{name:"8-17",
+                     junction:"AG",
+                     regions:[]Region{
+                         Region{name:"arm5",min:7,max:13},
+                         Region{name:"core",locked:true,seq:"TCCGAGCCGGACGA"},
+                         Region{name:"arm3",min:7,max:13},
+                     }},
+}
+
+// ParseTemplate() gets a builtin template by name or reads one from a file
+// input: template name or file name, "" for no template
+// output: pointer to the Template, nil if no template is used
+func ParseTemplate(name string) *Template {
+    if name == "" {
+        return nil
+    }
+    if template, ok := BUILTIN_TEMPLATES[name]; ok {
+        return &template
+    }
+    return ReadTemplate(name)
+}
+// ReadTemplate() reads a user defined template, one region per line as
+//   name locked SEQUENCE
+//   name mutable LENGTH       (fixed length)
+//   name mutable MIN-MAX      (length-variable)
+//   name junction MOTIF       (cleaved target dinucleotide, IUPAC, not a region)
+// blank lines and anything after # are ignored
+// input: template file name
+// output: pointer to the Template, panics if the file is invalid
+func ReadTemplate(filename string) *Template {
+    templateFile, err := os.Open(filename)
+    if err != nil { panic(err) }
+    defer templateFile.Close()
+    template := Template{name:filename,junction:"RY"}
+    scanner := bufio.NewScanner(templateFile)
+    for scanner.Scan() {
+        fields := strings.Fields(strings.Split(scanner.Text(),"#")[0])
+        if len(fields) == 0 {
+            continue
+        }
+        if len(fields) != 3 {
+            panic(fmt.Sprintf("Invalid template line %q, must be: name {locked|mutable|junction} spec",scanner.Text()))
+        }
+        region := Region{name:fields[0]}
+        switch fields[1] {
+            case "junction":
+                template.junction = strings.ToUpper(fields[2])
+                if len(template.junction) != 2 {
+                    panic(fmt.Sprintf("Invalid junction %q, must be a dinucleotide",fields[2]))
+                }
+                continue
+            case "locked":
+                region.locked = true
+                region.seq = strings.ToUpper(fields[2])
+                for _,base := range region.seq {
+                    if _, ok := DNA_COMPLEMENTS[base]; !ok {
+                        panic(fmt.Sprintf("Invalid base %q in locked region %s",base,region.name))
+                    }
+                }
+            case "mutable":
+                region.min, region.max = ParseRange(fields[2])
+            default:
+                panic(fmt.Sprintf("Invalid region type %q, must be {locked|mutable|junction}",fields[1]))
+        }
+        template.regions = append(template.regions,region)
+    }
+    if err := scanner.Err(); err != nil { panic(err) }
+    if len(template.regions) == 0 {
+        panic("Template " + filename + " has no regions")
+    }
+    return &template
+}
+
+// Join() the full sequence of a member from its region sequences
+func (t *Template) Join(segments []string) string {
+    return strings.Join(segments,"")
+}
+// RandomSegments() random region sequences following the template
+// mutable regions get a random sequence drawn with the composition with a random length in their bounds
+func (t *Template) RandomSegments(composition Composition) []string {
+    segments := make([]string,len(t.regions))
+    for i,region := range t.regions {
+        if region.locked {
+            segments[i] = region.seq
+        } else {
+            segments[i] = composition.RandomSeq(func() int { return region.min + RNG.Intn(region.max-region.min+1) })
+        }
+    }
+    return segments
+}
+// MakeTemplateMember() a random member following the template
+func (t *Template) MakeTemplateMember(composition Composition) Member {
+    segments := t.RandomSegments(composition)
+    return Member{seq:t.Join(segments),segments:segments}
+}
+// Segment() splits a sequence into the template regions
+// locked regions must match exactly and mutable regions must be within their length bounds,
+// shorter mutable regions are tried first
+// output: region sequences and whether the sequence fits the template
+func (t *Template) Segment(seq string) ([]string, bool) {
+    segments := make([]string,len(t.regions))
+    var fit func(region,start int) bool
+    fit = func(region,start int) bool {
+        if region == len(t.regions) {
+            return start == len(seq)
+        }
+        r := t.regions[region]
+        if r.locked {
+            if !strings.HasPrefix(seq[start:],r.seq) {
+                return false
+            }
+            segments[region] = r.seq
+            return fit(region+1,start+len(r.seq))
+        }
+        for length := r.min; length <= r.max && start+length <= len(seq); length++ {
+            segments[region] = seq[start:start+length]
+            if fit(region+1,start+length) {
+                return true
+            }
+        }
+        return false
+    }
+    return segments, fit(0,0)
+}
+// BindingSite() the target sequence a member's arms bind, 5'->3'
+// the 3' arm pairs just before the junction and the 5' arm from its second base,
+// the unpaired first base of the junction is N
+// input: member sequence and its segments, nil to segment the sequence
+// output: binding site, "" if the template has no arm5 and arm3 regions or the sequence does not fit it
+func (t *Template) BindingSite(seq string, segments []string) string {
+    if t == nil {
+        return ""
+    }
+    if segments == nil {
+        var ok bool
+        if segments, ok = t.Segment(strings.ToUpper(seq)); !ok {
+            return ""
+        }
+    }
+    arm5, arm3 := -1, -1
+    for i,region := range t.regions {
+        switch region.name {
+            case "arm5":
+                arm5 = i
+            case "arm3":
+                arm3 = i
+        }
+    }
+    if arm5 < 0 || arm3 < 0 || len(segments) != len(t.regions) {
+        return ""
+    }
+    return ReverseComplement(strings.ToUpper(segments[arm3])) + "N" + ReverseComplement(strings.ToUpper(segments[arm5]))
+}
+// MutableRegions() indices of the regions that can change
+func (t *Template) MutableRegions() []int {
+    var mutable []int
+    for i,region := range t.regions {
+        if !region.locked {
+            mutable = append(mutable,i)
+        }
+    }
+    return mutable
+}
+// Crossover() one-point crossover that respects the template
+// the cut is made inside a random mutable region, regions before it come
+// from a and regions after it from b, locked regions are kept as is
+// input: random numbers to draw from, region sequences of the 2 parents, operator crossing the cut region
+// output: region sequences of the child
+func (t *Template) Crossover(rng *rand.Rand, a,b []string, operator Crossover) []string {
+    child := make([]string,len(t.regions))
+    mutable := t.MutableRegions()
+    if len(mutable) == 0 {
+        copy(child,a)
+        return child
+    }
+    cut := mutable[rng.Intn(len(mutable))]
+    for i,region := range t.regions {
+        switch {
+            case region.locked:
+                child[i] = region.seq
+            case i < cut:
+                child[i] = a[i]
+            case i > cut:
+                child[i] = b[i]
+            default:
+                child[i] = operator(rng,a[i],b[i])
+                if !Between(float64(len(child[i])),float64(region.min),float64(region.max)) {
+                    child[i] = CrossoverSeqs(rng,a[i],b[i]) //homologous cuts can change the length
+                }
+        }
+    }
+    return child
+}
+// Mutate() mutates the region sequences of a member following the template
+// locked regions are untouched, fixed length regions only get substitutions
+// and length-variable regions get indels as long as they stay in their bounds
+// input: random numbers to draw from, region sequences, mutation model, mutation rate and indel rate
+// output: mutated region sequences
+func (t *Template) Mutate(rng *rand.Rand, segments []string, model *MutationModel, mutation_rate,indel_rate float64) []string {
+    mutated := make([]string,len(t.regions))
+    for i,region := range t.regions {
+        switch {
+            case region.locked:
+                mutated[i] = region.seq
+            case region.min == region.max:
+                mutated[i] = model.Mutate(rng,segments[i],mutation_rate,0)
+            default:
+                mutated[i] = model.Mutate(rng,segments[i],mutation_rate,indel_rate)
+                for tries := 0; !Between(float64(len(mutated[i])),float64(region.min),float64(region.max)); tries++ {
+                    if tries == 10 {//give up on indels for this region
+                        mutated[i] = model.Mutate(rng,segments[i],mutation_rate,0)
+                        break
+                    }
+                    mutated[i] = model.Mutate(rng,segments[i],mutation_rate,indel_rate)
+                }
+        }
+    }
+    return mutated
+}
@@ -6,8 +6,9 @@ import(
     "fmt"
     "sort"
     "math"
-    "math/rand"
     "strings"
+    "strconv"
+    "math/rand"
     "github.com/biogo/biogo/alphabet"
     "github.com/biogo/biogo/seq"
     "github.com/biogo/biogo/seq/linear"
@@ -38,6 +39,30 @@ func Mean(n []float64) float64 {
     }
     return total/float64(len(n))
 }
+// Sum() sum of a list of numbers
+func Sum(n []float64) float64 {
+    total := 0.0
+    for _,f := range n {
+        total += f
+    }
+    return total
+}
+// MinFloat() smallest of a non empty list of numbers
+func MinFloat(n []float64) float64 {
+    lowest := n[0]
+    for _,f := range n {
+        lowest = math.Min(lowest,f)
+    }
+    return lowest
+}
+// MaxFloat() largest of a non empty list of numbers
+func MaxFloat(n []float64) float64 {
+    highest := n[0]
+    for _,f := range n {
+        highest = math.Max(highest,f)
+    }
+    return highest
+}
 // StdDev() Stanrad deviation of n numbers
 func StdDev(n []float64) float64 {
     mean := Mean(n)
@@ -52,33 +77,60 @@ func CoV(n []float64) float64 {
     return math.Abs(StdDev(n)/Mean(n))
 }
 
+// InList() check if a string is one of a list of options
+func InList(value string, options []string) bool {
+    for _,option := range options {
+        if value == option {
+            return true
+        }
+    }
+    return false
+}
+
+// ParseRange() parses a length range written as N or MIN-MAX
+// output: min and max, equal for a single number, panics if invalid
+func ParseRange(spec string) (int,int) {
+    bounds := strings.Split(spec,"-")
+    min, err := strconv.Atoi(bounds[0])
+    if err != nil { panic(err) }
+    max := min
+    if len(bounds) == 2 {
+        max, err = strconv.Atoi(bounds[1])
+        if err != nil { panic(err) }
+    }
+    if len(bounds) > 2 || min < 1 || max < min {
+        panic(fmt.Sprintf("Invalid range %s, must be N or MIN-MAX with 0 < MIN <= MAX",spec))
+    }
+    return min,max
+}
+
 // RandomIntBetween() returns a random in between 2 other ints
-// input: lower and upper bounds
+// input: random numbers to draw from, lower and upper bounds
 // output: random int between lower and upper
 // from https://flaviocopes.com/go-random/
-func RandomIntBetween(lower,upper int) int {
+func RandomIntBetween(rng *rand.Rand, lower,upper int) int {
     if lower >= upper {
         panic("lower must be strictly smaller than upper")
     }
-    return lower + rand.Intn(upper-lower)
+    return lower + rng.Intn(upper-lower)
 }
 // PickRandomBase() picks a random DNA base
-func PickRandomBase() string {
-    return string(DNA_ALPHABET[rand.Intn(len(DNA_ALPHABET))])
+func PickRandomBase(rng *rand.Rand) string {
+    return string(DNA_ALPHABET[rng.Intn(len(DNA_ALPHABET))])
 }
 // PickDifferentRandomBase() picks a random DNA base that
 // is different from the base you pass as an argument
-func PickDifferentRandomBase(base rune) string {
+func PickDifferentRandomBase(rng *rand.Rand, base rune) string {
     var baseIndex int
     switch base {
         case 'A':// A is at position 0, avoid it
-            baseIndex = []int{1,2,3}[rand.Intn(3)]
+            baseIndex = []int{1,2,3}[rng.Intn(3)]
         case 'C':// C is at position 1, avoid it
-            baseIndex = []int{0,2,3}[rand.Intn(3)]
+            baseIndex = []int{0,2,3}[rng.Intn(3)]
         case 'G':// G is at position 2, avoid it
-            baseIndex = []int{0,1,3}[rand.Intn(3)]
+            baseIndex = []int{0,1,3}[rng.Intn(3)]
         case 'T':// T is at position 3, avoid it
-            baseIndex = []int{0,1,2}[rand.Intn(3)]
+            baseIndex = []int{0,1,2}[rng.Intn(3)]
     }
     return string(DNA_ALPHABET[baseIndex])
 }
@@ -115,6 +167,44 @@ func (pop Population) Summarize() {
     fmt.Println("-------------------------------------")
 }
 
+// TermNames() sorted names of the fitness terms scored for this population
+func (pop Population) TermNames() []string {
+    var terms []string
+    if len(pop) == 0 {
+        return terms
+    }
+    for term := range pop[0].scores {
+        terms = append(terms,term)
+    }
+    sort.Strings(terms)
+    return terms
+}
+
+// AnnotationNames() sorted names of the extra output values recorded for this population
+func (pop Population) AnnotationNames() []string {
+    var names []string
+    if len(pop) == 0 {
+        return names
+    }
+    for name := range pop[0].annotations {
+        names = append(names,name)
+    }
+    sort.Strings(names)
+    return names
+}
+// FormatAnnotation() an extra output value of a member, NA if it is missing, NaN or Inf
+func (s Member) FormatAnnotation(name string) string {
+    value, ok := s.annotations[name]
+    if !ok || math.IsNaN(value) || math.IsInf(value,0) {
+        return "NA"
+    }
+    return fmt.Sprintf("%f",value)
+}
+// SeqString() the sequence of a biogo *linear.Seq as a string
+func SeqString(s *linear.Seq) string {
+    return string(s.Seq)
+}
+
 // ReadTargetFromFasta() takes a fasta file and returns the first entry as a *linear.Seq object
 // input: fasta file name
 // output: biogo *linear.Seq object, can be aligned
@@ -171,6 +261,20 @@ func (s Member) ConvertToSeqObject() seq.Sequence {
     } else {
         label = fmt.Sprintf("%v | Fitness:%v",s.header,s.fitness,)
     }
+    if s.rank > 0 {//Pareto front and objective vector from nsga2 selection
+        terms := make([]string,0,len(s.scores))
+        for term := range s.scores {
+            terms = append(terms,term)
+        }
+        sort.Strings(terms)
+        label += fmt.Sprintf(" | ParetoRank:%v",s.rank)
+        for _,term := range terms {
+            label += fmt.Sprintf(" | %v:%v",term,s.scores[term])
+        }
+    }
+    if s.copies > 0 {//identical members collapsed into this one
+        label += fmt.Sprintf(" | Copies:%v",s.copies)
+    }
     return linear.NewSeq(label,[]alphabet.Letter(s.seq),alphabet.DNA)
 }
 
@@ -197,18 +301,50 @@ func (pop Population) WriteToTSV(filename string) {
         panic(err)
     }
     defer outfile.Close()
-    line := fmt.Sprint("Index\tSeqLabel\tFitness\tSequence\n")
-    outfile.WriteString(line)
+    terms := pop.TermNames()
+    annotations := pop.AnnotationNames()
+    ranked := len(pop) > 0 && pop[0].rank > 0
+    collapsed := len(pop) > 0 && pop[0].copies > 0
+    line := fmt.Sprint("Index\tSeqLabel\tFitness\tSequence")
+    if collapsed {
+        line += "\tCopies"
+    }
+    if ranked {
+        line += "\tParetoRank"
+    }
+    for _,term := range terms {//one column per fitness term
+        line += "\t" + term
+    }
+    for _,name := range annotations {//extra values recorded by the fitness terms
+        line += "\t" + name
+    }
+    outfile.WriteString(line + "\n")
     for i,member := range pop {
-        line = fmt.Sprintf("%d\tSequence_%d\t%f\t%s\n",i,member.label,member.fitness,member.seq)
-        outfile.WriteString(line)
+        line = fmt.Sprintf("%d\tSequence_%d\t%f\t%s",i,member.label,member.fitness,member.seq)
+        if collapsed {
+            line += fmt.Sprintf("\t%d",member.copies)
+        }
+        if ranked {
+            line += fmt.Sprintf("\t%d",member.rank)
+        }
+        for _,term := range terms {
+            line += fmt.Sprintf("\t%f",member.scores[term])
+        }
+        for _,name := range annotations {
+            line += "\t" + member.FormatAnnotation(name)
+        }
+        outfile.WriteString(line + "\n")
     }
 }
 // WriteResults () write every member of the population into a file, either tsv or fasta
-// output file may conatin < len(pop) entries Because it removes duplicates
+// output file may conatin < len(pop) entries Because it removes duplicates,
+// each written member records how many identical copies it stands for
 // input: population, list of members
 // output: no return, write file to filename
 func (pop Population) WriteResults(filename string) {
+    unique := pop.Collapse()
+    fmt.Printf("%d unique sequences, %d identical copies collapsed\n",len(unique),len(pop)-len(unique))
+    pop = unique
     splits := strings.Split(filename,".") //last element of split is the extension
     extension := splits[len(splits)-1] //last element of split is the extension
     switch extension {
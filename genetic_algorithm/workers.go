@@ -0,0 +1,54 @@
+package main
+
+import(
+    "sync"
+    "runtime"
+)
+
+//Parallel fitness evaluation
+//every fitness term is scored by a pool of WORKERS goroutines, members are handed
+//out one at a time or in chunks for terms that are cheaper to score in batches
+//scores are written by index so results do not depend on scheduling
+
+var WORKERS = runtime.GOMAXPROCS(0) //set with -workers
+
+// ChunkedTerm is a FitnessTerm that is cheaper to score many members per call
+type ChunkedTerm interface {
+    FitnessTerm
+    ChunkSize() int //members per call, 0 to score the whole population in one call
+}
+
+// ScoreParallel() scores a population with one fitness term using the worker pool
+// input: term to score and the population
+// output: score of every member, in population order
+func ScoreParallel(term FitnessTerm, pop Population) []float64 {
+    scores := make([]float64,len(pop))
+    if len(pop) == 0 {
+        return scores
+    }
+    size := 1
+    if chunked, ok := term.(ChunkedTerm); ok {
+        size = chunked.ChunkSize()
+        if size <= 0 {
+            size = len(pop)
+        }
+    }
+    chunks := make(chan int)
+    var wg sync.WaitGroup
+    for w := 0; w < Min(Max(1,WORKERS),(len(pop)+size-1)/size); w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for start := range chunks {
+                end := Min(start+size,len(pop))
+                copy(scores[start:end],term.Score(pop[start:end]))
+            }
+        }()
+    }
+    for start := 0; start < len(pop); start += size {
+        chunks <- start
+    }
+    close(chunks)
+    wg.Wait()
+    return scores
+}